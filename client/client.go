@@ -0,0 +1,258 @@
+// Package client is a Go client for the rate limiter service. It wraps the gRPC API with Allow, Wait and Reserve
+// helpers, applies a deadline to every call, and can optionally lease tokens from the server in batches and spend
+// them locally to cut the number of RPCs for hot keys.
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	pb "github.com/carteralbrecht/rate-limiter/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// defaultTimeout is the deadline applied to each RPC when WithTimeout is not used.
+	defaultTimeout = time.Second
+
+	// minWaitBackoff is the shortest Wait sleeps between checks when the server does not report a retry time.
+	minWaitBackoff = 10 * time.Millisecond
+
+	// defaultServiceConfig retries ReleaseLease while the server is briefly unavailable, e.g. while it restarts.
+	// Other calls consume or grant tokens and the server can fail them with UNAVAILABLE after they were applied,
+	// so retrying them could charge a request twice. gRPC still retries calls that never reached the server.
+	defaultServiceConfig = `{
+		"methodConfig": [{
+			"name": [{"service": "ratelimiter.RateLimiter", "method": "ReleaseLease"}],
+			"retryPolicy": {
+				"maxAttempts": 3,
+				"initialBackoff": "0.05s",
+				"maxBackoff": "0.5s",
+				"backoffMultiplier": 2,
+				"retryableStatusCodes": ["UNAVAILABLE"]
+			}
+		}]
+	}`
+)
+
+// ErrExceedsLimit is returned by Wait when the cost is larger than the key's limit, so waiting would never help.
+var ErrExceedsLimit = errors.New("client: token cost exceeds the limit and can never be allowed")
+
+// Decision is the server's answer to a check.
+type Decision struct {
+	// Allowed reports whether the request can proceed.
+	Allowed bool
+
+	// Remaining is the number of tokens left for the key. With local tokens, it is the number left in the
+	// local batch.
+	Remaining int
+
+	// Limit is the most tokens the key can have available at once.
+	Limit int
+
+	// RetryAfter is how long until the same request would be allowed. Zero if allowed, negative if it never will be.
+	RetryAfter time.Duration
+
+	// ResetAfter is how long until the limit is fully replenished. Negative if it never will be.
+	ResetAfter time.Duration
+}
+
+// Client checks limits against a rate limiter server. It is safe for concurrent use.
+type Client struct {
+	rl      pb.RateLimiterClient
+	conn    *grpc.ClientConn
+	timeout time.Duration
+	local   *localTokens
+}
+
+type options struct {
+	timeout     time.Duration
+	dialOptions []grpc.DialOption
+	localBatch  int
+	localTTL    time.Duration
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithTimeout sets the deadline applied to each RPC, unless the caller's context expires sooner. Zero disables it.
+// Defaults to one second.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.timeout = timeout }
+}
+
+// WithDialOptions adds gRPC dial options used by New, e.g. transport credentials. Connections are insecure
+// unless credentials are given.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) { o.dialOptions = append(o.dialOptions, opts...) }
+}
+
+// WithLocalTokens makes Allow consume batch tokens from the server at a time and spend them locally, until they
+// run out or ttl passes. This cuts RPCs by up to a factor of batch, at the cost of accuracy: tokens leased by one
+// client cannot be spent by others, and unspent tokens are lost when they expire or the client is closed. A short
+// ttl bounds how long leased tokens are held back from other clients. Reserve and Wait also use local tokens.
+func WithLocalTokens(batch int, ttl time.Duration) Option {
+	return func(o *options) {
+		o.localBatch = batch
+		o.localTTL = ttl
+	}
+}
+
+// New connects to the rate limiter server at target, e.g. "localhost:50051". The connection is established
+// lazily and re-established as needed; Close releases it.
+func New(target string, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+
+	dialOptions := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(defaultServiceConfig),
+	}, o.dialOptions...)
+	conn, err := grpc.NewClient(target, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClient(pb.NewRateLimiterClient(conn), o)
+	c.conn = conn
+	return c, nil
+}
+
+// NewFromConn creates a Client using an existing connection, which Close leaves open.
+func NewFromConn(conn grpc.ClientConnInterface, opts ...Option) *Client {
+	return newClient(pb.NewRateLimiterClient(conn), newOptions(opts))
+}
+
+func newOptions(opts []Option) options {
+	o := options{timeout: defaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func newClient(rl pb.RateLimiterClient, o options) *Client {
+	c := &Client{rl: rl, timeout: o.timeout}
+	if o.localBatch > 1 && o.localTTL > 0 {
+		c.local = newLocalTokens(o.localBatch, o.localTTL)
+	}
+	return c
+}
+
+// Close closes the connection if the Client created it.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// withTimeout applies the client's per-call deadline to ctx.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// check consumes cost tokens for key on the server.
+func (c *Client) check(ctx context.Context, key string, cost int) (Decision, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := c.rl.CheckLimit(ctx, &pb.CheckRequest{Key: key, TokenCost: int32(cost)})
+	if err != nil {
+		return Decision{}, err
+	}
+	return Decision{
+		Allowed:    resp.Allowed,
+		Remaining:  int(resp.Remaining),
+		Limit:      int(resp.Limit),
+		RetryAfter: resp.RetryAfter.AsDuration(),
+		ResetAfter: resp.ResetAfter.AsDuration(),
+	}, nil
+}
+
+// Allow consumes cost tokens for key if they are available. It does not wait; a denied Decision reports when to
+// retry.
+func (c *Client) Allow(ctx context.Context, key string, cost int) (Decision, error) {
+	if c.local != nil {
+		return c.local.allow(ctx, key, cost, c.check)
+	}
+	return c.check(ctx, key, cost)
+}
+
+// Wait blocks until cost tokens for key are consumed, sleeping for the retry time the server reports after each
+// denial. It returns ErrExceedsLimit if the cost can never be allowed, and the context's error if it is done, or
+// its deadline would pass, before the tokens are available.
+func (c *Client) Wait(ctx context.Context, key string, cost int) error {
+	for {
+		decision, err := c.Allow(ctx, key, cost)
+		if err != nil {
+			return err
+		}
+		if decision.Allowed {
+			return nil
+		}
+		if decision.RetryAfter < 0 {
+			return ErrExceedsLimit
+		}
+
+		delay := max(decision.RetryAfter, minWaitBackoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return context.DeadlineExceeded
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Reservation is the outcome of Reserve. If allowed, its tokens are already consumed and can be handed back with
+// Cancel if the work they were reserved for does not happen.
+type Reservation struct {
+	Decision
+
+	client   *Client
+	key      string
+	cost     int
+	canceled sync.Once
+}
+
+// Reserve consumes cost tokens for key if they are available, like Allow, and returns a Reservation that can
+// return them.
+func (c *Client) Reserve(ctx context.Context, key string, cost int) (*Reservation, error) {
+	decision, err := c.Allow(ctx, key, cost)
+	if err != nil {
+		return nil, err
+	}
+	return &Reservation{Decision: decision, client: c, key: key, cost: cost}, nil
+}
+
+// Cancel returns the reservation's tokens by refilling the key's bucket, so they can be spent by other requests.
+// Refilling only affects token bucket policies. It does nothing if the reservation was denied or already
+// canceled.
+func (r *Reservation) Cancel(ctx context.Context) error {
+	if !r.Allowed || r.cost <= 0 {
+		return nil
+	}
+
+	var err error
+	r.canceled.Do(func() {
+		if r.client.local != nil && r.client.local.refund(r.key, r.cost) {
+			return
+		}
+
+		ctx, cancel := r.client.withTimeout(ctx)
+		defer cancel()
+		_, err = r.client.rl.RefillBucket(ctx, &pb.RefillRequest{Key: r.key, LeakRate: int32(r.cost)})
+	})
+	return err
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/carteralbrecht/rate-limiter/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// fakeServer answers checks with a function and records every request.
+type fakeServer struct {
+	pb.UnimplementedRateLimiterServer
+
+	mu       sync.Mutex
+	check    func(req *pb.CheckRequest) (*pb.CheckResponse, error)
+	checks   []*pb.CheckRequest
+	refills  []*pb.RefillRequest
+	releases int
+}
+
+func (s *fakeServer) CheckLimit(ctx context.Context, req *pb.CheckRequest) (*pb.CheckResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, req)
+	return s.check(req)
+}
+
+func (s *fakeServer) RefillBucket(ctx context.Context, req *pb.RefillRequest) (*pb.RefillResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refills = append(s.refills, req)
+	return &pb.RefillResponse{CurrentTokens: req.LeakRate}, nil
+}
+
+// ReleaseLease fails with Unavailable for the first release, and releases every lease after that.
+func (s *fakeServer) ReleaseLease(ctx context.Context, req *pb.ReleaseLeaseRequest) (*pb.ReleaseLeaseResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releases++
+	if s.releases == 1 {
+		return nil, status.Error(codes.Unavailable, "backend unavailable")
+	}
+	return &pb.ReleaseLeaseResponse{Released: true}, nil
+}
+
+func (s *fakeServer) costs() []int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var costs []int32
+	for _, req := range s.checks {
+		costs = append(costs, req.TokenCost)
+	}
+	return costs
+}
+
+// bucketServer is a fakeServer backed by a bucket of tokens that never refills.
+func bucketServer(tokens int32) *fakeServer {
+	return &fakeServer{check: func(req *pb.CheckRequest) (*pb.CheckResponse, error) {
+		if req.TokenCost > tokens {
+			return &pb.CheckResponse{Remaining: tokens, Limit: 100, RetryAfter: durationpb.New(-1)}, nil
+		}
+		tokens -= req.TokenCost
+		return &pb.CheckResponse{Allowed: true, Remaining: tokens, Limit: 100, RetryAfter: durationpb.New(0)}, nil
+	}}
+}
+
+func newTestClient(t *testing.T, srv *fakeServer, opts ...Option) *Client {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	pb.RegisterRateLimiterServer(gs, srv)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	opts = append(opts, WithDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}), grpc.WithTransportCredentials(insecure.NewCredentials())))
+	c, err := New("passthrough:///bufnet", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestAllow_MapsResponse(t *testing.T) {
+	// Arrange
+	srv := &fakeServer{check: func(req *pb.CheckRequest) (*pb.CheckResponse, error) {
+		return &pb.CheckResponse{Remaining: 3, Limit: 10, RetryAfter: durationpb.New(2 * time.Second), ResetAfter: durationpb.New(7 * time.Second)}, nil
+	}}
+	c := newTestClient(t, srv)
+
+	// Act
+	decision, err := c.Allow(context.Background(), "user:1", 5)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Remaining: 3, Limit: 10, RetryAfter: 2 * time.Second, ResetAfter: 7 * time.Second}, decision)
+	assert.Equal(t, []int32{5}, srv.costs())
+}
+
+func TestAllow_AppliesTimeout(t *testing.T) {
+	// Arrange
+	srv := &fakeServer{check: func(req *pb.CheckRequest) (*pb.CheckResponse, error) {
+		time.Sleep(200 * time.Millisecond)
+		return &pb.CheckResponse{Allowed: true}, nil
+	}}
+	c := newTestClient(t, srv, WithTimeout(20*time.Millisecond))
+
+	// Act
+	_, err := c.Allow(context.Background(), "user:1", 1)
+
+	// Assert
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestAllow_DoesNotRetryUnavailable(t *testing.T) {
+	// Arrange
+	srv := &fakeServer{check: func(req *pb.CheckRequest) (*pb.CheckResponse, error) {
+		return nil, status.Error(codes.Unavailable, "backend unavailable")
+	}}
+	c := newTestClient(t, srv)
+
+	// Act
+	_, err := c.Allow(context.Background(), "user:1", 1)
+
+	// Assert
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, []int32{1}, srv.costs(), "The check may have consumed tokens, so it should not be sent again")
+}
+
+func TestReleaseLease_RetriesUnavailable(t *testing.T) {
+	// Arrange
+	srv := &fakeServer{}
+	c := newTestClient(t, srv)
+
+	// Act
+	resp, err := c.rl.ReleaseLease(context.Background(), &pb.ReleaseLeaseRequest{Key: "tenant:1", LeaseId: "lease"})
+
+	// Assert
+	require.NoError(t, err)
+	assert.True(t, resp.Released)
+	assert.Equal(t, 2, srv.releases, "Releasing is safe to repeat, so it should be retried")
+}
+
+func TestWait_RetriesAfterDenial(t *testing.T) {
+	// Arrange
+	calls := 0
+	srv := &fakeServer{check: func(req *pb.CheckRequest) (*pb.CheckResponse, error) {
+		calls++
+		if calls == 1 {
+			return &pb.CheckResponse{RetryAfter: durationpb.New(30 * time.Millisecond)}, nil
+		}
+		return &pb.CheckResponse{Allowed: true}, nil
+	}}
+	c := newTestClient(t, srv)
+	start := time.Now()
+
+	// Act
+	err := c.Wait(context.Background(), "user:1", 1)
+
+	// Assert
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond, "Wait should sleep for the retry time")
+	assert.Equal(t, 2, calls)
+}
+
+func TestWait_ExceedsLimit(t *testing.T) {
+	// Arrange
+	c := newTestClient(t, bucketServer(10))
+
+	// Act
+	err := c.Wait(context.Background(), "user:1", 11)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrExceedsLimit)
+}
+
+func TestWait_DeadlineTooSoon(t *testing.T) {
+	// Arrange
+	srv := &fakeServer{check: func(req *pb.CheckRequest) (*pb.CheckResponse, error) {
+		return &pb.CheckResponse{RetryAfter: durationpb.New(time.Minute)}, nil
+	}}
+	c := newTestClient(t, srv)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Act
+	err := c.Wait(ctx, "user:1", 1)
+
+	// Assert
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "Wait should give up without sleeping past the deadline")
+}
+
+func TestReserve_CancelRefills(t *testing.T) {
+	// Arrange
+	srv := bucketServer(10)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	// Act
+	reservation, err := c.Reserve(ctx, "user:1", 4)
+	require.NoError(t, err)
+	require.NoError(t, reservation.Cancel(ctx))
+	require.NoError(t, reservation.Cancel(ctx))
+
+	// Assert
+	assert.True(t, reservation.Allowed)
+	require.Len(t, srv.refills, 1, "Canceling twice should only refill once")
+	assert.Equal(t, "user:1", srv.refills[0].Key)
+	assert.Equal(t, int32(4), srv.refills[0].LeakRate)
+}
+
+func TestReserve_CancelDeniedDoesNothing(t *testing.T) {
+	// Arrange
+	srv := bucketServer(1)
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	// Act
+	reservation, err := c.Reserve(ctx, "user:1", 4)
+	require.NoError(t, err)
+	require.NoError(t, reservation.Cancel(ctx))
+
+	// Assert
+	assert.False(t, reservation.Allowed)
+	assert.Empty(t, srv.refills)
+}
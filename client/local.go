@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// localTokens holds tokens consumed from the server in batches, per key, so that most checks are answered without
+// an RPC.
+type localTokens struct {
+	batch int
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*localBucket
+	pruned  time.Time
+}
+
+// localBucket is the unspent part of the last batch leased for a key.
+type localBucket struct {
+	mu        sync.Mutex
+	tokens    int
+	limit     int
+	expiresAt time.Time
+}
+
+func newLocalTokens(batch int, ttl time.Duration) *localTokens {
+	return &localTokens{
+		batch:   batch,
+		ttl:     ttl,
+		now:     time.Now,
+		buckets: make(map[string]*localBucket),
+	}
+}
+
+// bucket returns the bucket for key, creating it if needed. Expired buckets of other keys are dropped at most once
+// per ttl, so keys that are no longer used do not accumulate.
+func (l *localTokens) bucket(key string) *localBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.pruned) >= l.ttl {
+		for k, b := range l.buckets {
+			// Skip buckets in use; they are pruned next time
+			if b.mu.TryLock() {
+				if !now.Before(b.expiresAt) {
+					delete(l.buckets, k)
+				}
+				b.mu.Unlock()
+			}
+		}
+		l.pruned = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &localBucket{}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// lock returns the bucket for key, locked. A bucket pruned after it was looked up but before it was locked is no
+// longer in the map, so the lookup is retried rather than leasing tokens into a bucket no other caller will find.
+// Once locked, a bucket cannot be pruned, since pruning skips buckets it cannot lock.
+func (l *localTokens) lock(key string) *localBucket {
+	for {
+		b := l.bucket(key)
+		b.mu.Lock()
+
+		l.mu.Lock()
+		current := l.buckets[key] == b
+		l.mu.Unlock()
+		if current {
+			return b
+		}
+		b.mu.Unlock()
+	}
+}
+
+// allow spends cost tokens from the key's local batch, leasing a new batch with check when it runs out or expires.
+// If the server cannot cover a whole batch, the request falls back to consuming exactly cost tokens, so it is only
+// denied when the server would deny it without local tokens. Callers for the same key wait for one lease rather
+// than each sending their own.
+func (l *localTokens) allow(ctx context.Context, key string, cost int, check func(context.Context, string, int) (Decision, error)) (Decision, error) {
+	if cost <= 0 {
+		return check(ctx, key, cost)
+	}
+
+	b := l.lock(key)
+	defer b.mu.Unlock()
+
+	now := l.now()
+	if !now.Before(b.expiresAt) {
+		b.tokens = 0
+	}
+	if b.tokens >= cost {
+		b.tokens -= cost
+		return Decision{Allowed: true, Remaining: b.tokens, Limit: b.limit}, nil
+	}
+
+	lease := max(l.batch, cost)
+	decision, err := check(ctx, key, lease)
+	if err != nil {
+		return Decision{}, err
+	}
+	if !decision.Allowed {
+		if lease == cost {
+			return decision, nil
+		}
+		return check(ctx, key, cost)
+	}
+
+	b.tokens += lease - cost
+	b.limit = decision.Limit
+	b.expiresAt = now.Add(l.ttl)
+	decision.Remaining = b.tokens
+	return decision, nil
+}
+
+// refund returns cost tokens to the key's local batch. It reports false if the batch has expired, in which case
+// the tokens must be returned to the server instead.
+func (l *localTokens) refund(key string, cost int) bool {
+	b := l.lock(key)
+	defer b.mu.Unlock()
+
+	if !l.now().Before(b.expiresAt) {
+		return false
+	}
+	b.tokens += cost
+	return true
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalTokens_SpendsBatchLocally(t *testing.T) {
+	// Arrange
+	srv := bucketServer(100)
+	c := newTestClient(t, srv, WithLocalTokens(10, time.Minute))
+	ctx := context.Background()
+
+	// Act
+	var decisions []Decision
+	for range 12 {
+		decision, err := c.Allow(ctx, "user:1", 1)
+		require.NoError(t, err)
+		decisions = append(decisions, decision)
+	}
+
+	// Assert
+	assert.Equal(t, []int32{10, 10}, srv.costs(), "Twelve checks should lease two batches")
+	assert.Equal(t, Decision{Allowed: true, Remaining: 9, Limit: 100, RetryAfter: 0}, decisions[0])
+	assert.Equal(t, Decision{Allowed: true, Remaining: 0, Limit: 100}, decisions[9])
+	assert.Equal(t, 8, decisions[11].Remaining)
+}
+
+func TestLocalTokens_FallsBackToExactCost(t *testing.T) {
+	// Arrange
+	srv := bucketServer(3)
+	c := newTestClient(t, srv, WithLocalTokens(10, time.Minute))
+	ctx := context.Background()
+
+	// Act
+	allowed, err := c.Allow(ctx, "user:1", 2)
+	require.NoError(t, err)
+	denied, err := c.Allow(ctx, "user:1", 2)
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, allowed.Allowed, "A request should be allowed when the server cannot cover a whole batch")
+	assert.False(t, denied.Allowed)
+	assert.Equal(t, []int32{10, 2, 10, 2}, srv.costs())
+}
+
+func TestLocalTokens_Expire(t *testing.T) {
+	// Arrange
+	srv := bucketServer(100)
+	c := newTestClient(t, srv, WithLocalTokens(10, time.Minute))
+	now := time.Now()
+	c.local.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Act
+	_, err := c.Allow(ctx, "user:1", 1)
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, err = c.Allow(ctx, "user:1", 1)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, []int32{10, 10}, srv.costs(), "Expired tokens should not be spent")
+	assert.Len(t, c.local.buckets, 1)
+}
+
+func TestLocalTokens_CancelRefundsLocally(t *testing.T) {
+	// Arrange
+	srv := bucketServer(100)
+	c := newTestClient(t, srv, WithLocalTokens(10, time.Minute))
+	ctx := context.Background()
+
+	// Act
+	reservation, err := c.Reserve(ctx, "user:1", 3)
+	require.NoError(t, err)
+	require.NoError(t, reservation.Cancel(ctx))
+	decision, err := c.Allow(ctx, "user:1", 1)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Empty(t, srv.refills, "Tokens from a local batch should be returned to it")
+	assert.Equal(t, 9, decision.Remaining)
+}
+
+func TestLocalTokens_Concurrent(t *testing.T) {
+	// Arrange
+	srv := bucketServer(50)
+	c := newTestClient(t, srv, WithLocalTokens(10, time.Minute))
+	ctx := context.Background()
+
+	// Act
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				decision, err := c.Allow(ctx, "user:1", 1)
+				if assert.NoError(t, err) && decision.Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	assert.Equal(t, 50, allowed, "Local tokens should never be spent twice")
+}
+
+func TestLocalTokens_PrunedWhileWaitingForLock(t *testing.T) {
+	// Arrange
+	srv := bucketServer(100)
+	c := newTestClient(t, srv, WithLocalTokens(10, time.Minute))
+	start := time.Now()
+	c.local.now = func() time.Time { return start }
+	ctx := context.Background()
+	_, err := c.Allow(ctx, "user:1", 1)
+	require.NoError(t, err)
+
+	// The bucket has expired, but pruning is not due yet
+	now := start.Add(time.Minute)
+	c.local.pruned = now
+	stale := c.local.buckets["user:1"]
+	looked := make(chan struct{}, 1)
+	c.local.now = func() time.Time {
+		select {
+		case looked <- struct{}{}:
+		default:
+		}
+		return now
+	}
+
+	// Act
+	stale.mu.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := c.Allow(ctx, "user:1", 1)
+		done <- err
+	}()
+
+	// Prune the bucket once the caller has looked it up, before it can lock it
+	<-looked
+	c.local.mu.Lock()
+	delete(c.local.buckets, "user:1")
+	c.local.mu.Unlock()
+	stale.mu.Unlock()
+	require.NoError(t, <-done)
+
+	decision, err := c.Allow(ctx, "user:1", 1)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, []int32{10, 10}, srv.costs(), "The batch leased after the prune should be found by later callers")
+	assert.Equal(t, 8, decision.Remaining)
+}
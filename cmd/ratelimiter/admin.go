@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+
+	pb "github.com/carteralbrecht/rate-limiter/proto"
+	"github.com/carteralbrecht/rate-limiter/server"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// adminServer serves the RateLimiterAdmin service, which inspects and changes keys' limiter state through the
+// RateLimiter so each algorithm's state is handled correctly.
+type adminServer struct {
+	pb.UnimplementedRateLimiterAdminServer
+	rateLimiter *server.RateLimiter
+}
+
+func (s *adminServer) GetBucket(ctx context.Context, req *pb.GetBucketRequest) (*pb.GetBucketResponse, error) {
+	bucket, err := s.rateLimiter.GetBucket(ctx, req.Key)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.GetBucketResponse{Bucket: bucketResponse(bucket)}, nil
+}
+
+func (s *adminServer) ResetBucket(ctx context.Context, req *pb.ResetBucketRequest) (*pb.ResetBucketResponse, error) {
+	bucket, err := s.rateLimiter.ResetBucket(ctx, req.Key)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.ResetBucketResponse{Bucket: bucketResponse(bucket)}, nil
+}
+
+func (s *adminServer) SetTokens(ctx context.Context, req *pb.SetTokensRequest) (*pb.SetTokensResponse, error) {
+	bucket, err := s.rateLimiter.SetTokens(ctx, req.Key, int(req.Tokens))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.SetTokensResponse{Bucket: bucketResponse(bucket)}, nil
+}
+
+func (s *adminServer) DeleteBucket(ctx context.Context, req *pb.DeleteBucketRequest) (*pb.DeleteBucketResponse, error) {
+	deleted, err := s.rateLimiter.DeleteBucket(ctx, req.Key)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.DeleteBucketResponse{Deleted: deleted}, nil
+}
+
+// bucketResponse converts the state of a key's limit to its protobuf form.
+func bucketResponse(bucket server.Bucket) *pb.Bucket {
+	return &pb.Bucket{
+		Key:           bucket.Key,
+		Policy:        bucket.Policy.Name,
+		Algorithm:     string(bucket.Policy.Algorithm),
+		Remaining:     int32(bucket.Remaining),
+		Limit:         int32(bucket.Limit),
+		ResetAfter:    durationpb.New(bucket.ResetAfter),
+		LeasesInUse:   int32(bucket.LeasesInUse),
+		MaxConcurrent: int32(bucket.Policy.MaxConcurrent),
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/carteralbrecht/rate-limiter/server"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// envoyRateLimitServer implements Envoy's global rate limit service (RLS v3) on top of the rate limiter, so Envoy
+// can be pointed at this service instead of a separate rate limit service.
+type envoyRateLimitServer struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	*rateLimiterServer
+}
+
+// envoyUnits are the time units Envoy can report a limit in, shortest first.
+var envoyUnits = []struct {
+	duration time.Duration
+	unit     rlsv3.RateLimitResponse_RateLimit_Unit
+}{
+	{time.Second, rlsv3.RateLimitResponse_RateLimit_SECOND},
+	{time.Minute, rlsv3.RateLimitResponse_RateLimit_MINUTE},
+	{time.Hour, rlsv3.RateLimitResponse_RateLimit_HOUR},
+	{24 * time.Hour, rlsv3.RateLimitResponse_RateLimit_DAY},
+}
+
+// ShouldRateLimit checks every descriptor in a single pipelined round trip. Each descriptor is mapped to a key by
+// envoyKey and limited by the policy matching that key. The request is over the limit if any descriptor is. Tokens
+// consumed by the other descriptors stay consumed when one of them fails. Backend failures return Unavailable, so
+// Envoy applies its failure_mode_deny setting rather than seeing a denial, unless another descriptor is already over
+// the limit, in which case the failed descriptors are reported as UNKNOWN in an OVER_LIMIT response.
+func (s *envoyRateLimitServer) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	start := time.Now()
+	defer func() {
+		s.duration.Record(ctx, time.Since(start).Seconds(),
+			metric.WithAttributes(
+				attribute.String("domain", req.Domain),
+			),
+		)
+	}()
+
+	// Envoy sends 0 when hits_addend is not configured, which means 1
+	hits := int(max(req.HitsAddend, 1))
+
+	descriptors := make([]server.Descriptor, len(req.Descriptors))
+	for i, d := range req.Descriptors {
+		descriptors[i] = server.Descriptor{Key: envoyKey(req.Domain, d), TokenCost: hits}
+		if d.HitsAddend != nil {
+			descriptors[i].TokenCost = int(d.HitsAddend.Value)
+		}
+	}
+
+	policies := s.rateLimiter.Policies()
+	resp := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+	results, errs := s.rateLimiter.CheckAndConsumeTokensBatchWithPolicies(ctx, policies, descriptors)
+	var failed error
+	for i, result := range results {
+		key := descriptors[i].Key
+		if errs[i] != nil {
+			if err := s.handlerError(ctx, key, errs[i]); failed == nil {
+				failed = err
+			}
+			resp.Statuses = append(resp.Statuses, &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_UNKNOWN})
+			continue
+		}
+		s.recordResult(ctx, key, result)
+
+		status := &rlsv3.RateLimitResponse_DescriptorStatus{
+			Code:               rlsv3.RateLimitResponse_OK,
+			CurrentLimit:       envoyCurrentLimit(policies.Match(key)),
+			LimitRemaining:     uint32(max(result.Remaining, 0)),
+			DurationUntilReset: durationpb.New(max(result.ResetAfter, 0)),
+		}
+		if !result.Allowed {
+			status.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+			resp.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		resp.Statuses = append(resp.Statuses, status)
+	}
+
+	// A request already over the limit is denied whatever the failed descriptors would have said. Otherwise its
+	// outcome is unknown, so the whole request fails and Envoy applies failure_mode_deny.
+	if failed != nil && resp.OverallCode != rlsv3.RateLimitResponse_OVER_LIMIT {
+		return nil, failed
+	}
+	return resp, nil
+}
+
+// envoyKeyEscaper escapes the separators of envoyKey within the domain and entries, so distinct descriptors never
+// map to the same key.
+var envoyKeyEscaper = strings.NewReplacer(`\`, `\\`, ":", `\:`, "=", `\=`)
+
+// envoyKey flattens an Envoy descriptor into a rate limiter key: the domain followed by each entry as key=value,
+// separated by colons. For example, the descriptor [remote_address=10.0.0.1] in the "ingress" domain maps to
+// "ingress:remote_address=10.0.0.1". Entries without a value contribute only their key. Backslashes, colons and
+// equals signs within the domain, keys and values are escaped with a backslash, e.g. the IPv6 address ::1 maps to
+// "ingress:remote_address=\:\:1".
+func envoyKey(domain string, descriptor *ratelimitv3.RateLimitDescriptor) string {
+	var b strings.Builder
+	envoyKeyEscaper.WriteString(&b, domain)
+	for _, entry := range descriptor.Entries {
+		b.WriteString(":")
+		envoyKeyEscaper.WriteString(&b, entry.Key)
+		if entry.Value != "" {
+			b.WriteString("=")
+			envoyKeyEscaper.WriteString(&b, entry.Value)
+		}
+	}
+	return b.String()
+}
+
+// envoyCurrentLimit describes the limit enforced by policy in the units Envoy understands, which Envoy uses for
+// its X-RateLimit-Limit header. Returns nil if the limit cannot be expressed as a whole number of requests per
+// second, minute, hour or day.
+func envoyCurrentLimit(policy server.Policy) *rlsv3.RateLimitResponse_RateLimit {
+	for _, u := range envoyUnits {
+		switch policy.Algorithm {
+		case server.TokenBucket, server.GCRA:
+			perUnit := policy.RefillRate * u.duration.Seconds()
+			if perUnit >= 1 && math.Abs(perUnit-math.Round(perUnit)) < 1e-9 {
+				return &rlsv3.RateLimitResponse_RateLimit{Name: policy.Name, RequestsPerUnit: uint32(math.Round(perUnit)), Unit: u.unit}
+			}
+		default:
+			if policy.Window == u.duration {
+				return &rlsv3.RateLimitResponse_RateLimit{Name: policy.Name, RequestsPerUnit: uint32(policy.Limit), Unit: u.unit}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/carteralbrecht/rate-limiter/server"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// envoyDescriptor builds a descriptor from alternating entry keys and values.
+func envoyDescriptor(kv ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+func TestEnvoyKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		domain     string
+		descriptor *ratelimitv3.RateLimitDescriptor
+		want       string
+	}{
+		{"single entry", "ingress", envoyDescriptor("remote_address", "10.0.0.1"), "ingress:remote_address=10.0.0.1"},
+		{"several entries", "ingress", envoyDescriptor("tenant", "acme", "path", "/v1"), "ingress:tenant=acme:path=/v1"},
+		{"entry without value", "ingress", envoyDescriptor("generic_key", ""), "ingress:generic_key"},
+		{"colons in value", "ingress", envoyDescriptor("remote_address", "::1"), `ingress:remote_address=\:\:1`},
+		{"separators in key", "ingress", envoyDescriptor("a=b:c", "d"), `ingress:a\=b\:c=d`},
+		{"backslash", `in\gress`, envoyDescriptor("k", `v\`), `in\\gress:k=v\\`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, envoyKey(tt.domain, tt.descriptor))
+		})
+	}
+}
+
+func TestEnvoyKey_DistinctDescriptorsDoNotCollide(t *testing.T) {
+	// Each pair used to flatten to the same key
+	pairs := [][2]*ratelimitv3.RateLimitDescriptor{
+		{envoyDescriptor("a", "b:c=d"), envoyDescriptor("a", "b", "c", "d")},
+		{envoyDescriptor("a=b", ""), envoyDescriptor("a", "b")},
+		{envoyDescriptor("a", `b\`, "c", ""), envoyDescriptor("a", `b\:c`)},
+	}
+
+	for _, p := range pairs {
+		assert.NotEqual(t, envoyKey("ingress", p[0]), envoyKey("ingress", p[1]))
+	}
+}
+
+func TestEnvoyCurrentLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy server.Policy
+		want   *rlsv3.RateLimitResponse_RateLimit
+	}{
+		{"token bucket per second", server.Policy{Name: "p", Algorithm: server.TokenBucket, Capacity: 20, RefillRate: 10},
+			&rlsv3.RateLimitResponse_RateLimit{Name: "p", RequestsPerUnit: 10, Unit: rlsv3.RateLimitResponse_RateLimit_SECOND}},
+		{"gcra per minute", server.Policy{Name: "p", Algorithm: server.GCRA, Capacity: 5, RefillRate: 0.5},
+			&rlsv3.RateLimitResponse_RateLimit{Name: "p", RequestsPerUnit: 30, Unit: rlsv3.RateLimitResponse_RateLimit_MINUTE}},
+		{"window of an hour", server.Policy{Name: "p", Algorithm: server.SlidingWindowLog, Limit: 100, Window: time.Hour},
+			&rlsv3.RateLimitResponse_RateLimit{Name: "p", RequestsPerUnit: 100, Unit: rlsv3.RateLimitResponse_RateLimit_HOUR}},
+		{"window Envoy cannot express", server.Policy{Name: "p", Algorithm: server.FixedWindow, Limit: 100, Window: 90 * time.Second}, nil},
+		{"rate Envoy cannot express", server.Policy{Name: "p", Algorithm: server.TokenBucket, Capacity: 5, RefillRate: 1.0 / 7}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, envoyCurrentLimit(tt.policy))
+		})
+	}
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	// Arrange
+	s, _ := newTestServer(t, server.Policy{Name: "ips", Prefix: "ingress:remote_address=", Capacity: 2, RefillRate: 1})
+	envoy := &envoyRateLimitServer{rateLimiterServer: s}
+	ctx := context.Background()
+	req := &rlsv3.RateLimitRequest{
+		Domain: "ingress",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{
+			envoyDescriptor("remote_address", "10.0.0.1"),
+			envoyDescriptor("tenant", "acme"),
+		},
+	}
+
+	// Act
+	first, err := envoy.ShouldRateLimit(ctx, req)
+	require.NoError(t, err)
+	req.Descriptors[1].HitsAddend = wrapperspb.UInt64(9)
+	second, err := envoy.ShouldRateLimit(ctx, req)
+	require.NoError(t, err)
+	third, err := envoy.ShouldRateLimit(ctx, req)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, first.OverallCode)
+	assert.Equal(t, []*rlsv3.RateLimitResponse_DescriptorStatus{
+		{
+			Code:               rlsv3.RateLimitResponse_OK,
+			CurrentLimit:       &rlsv3.RateLimitResponse_RateLimit{Name: "ips", RequestsPerUnit: 1, Unit: rlsv3.RateLimitResponse_RateLimit_SECOND},
+			LimitRemaining:     1,
+			DurationUntilReset: durationpb.New(time.Second),
+		},
+		{
+			Code:               rlsv3.RateLimitResponse_OK,
+			CurrentLimit:       &rlsv3.RateLimitResponse_RateLimit{Name: "default", RequestsPerUnit: 1, Unit: rlsv3.RateLimitResponse_RateLimit_SECOND},
+			LimitRemaining:     9,
+			DurationUntilReset: durationpb.New(time.Second),
+		},
+	}, first.Statuses)
+
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, second.OverallCode, "The descriptor's hits_addend should be its cost")
+	assert.Equal(t, uint32(0), second.Statuses[1].LimitRemaining)
+
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, third.OverallCode, "The request should be over the limit if any descriptor is")
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, third.Statuses[0].Code)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, third.Statuses[1].Code)
+}
+
+func TestShouldRateLimit_BackendError(t *testing.T) {
+	// Arrange
+	s, _ := newTestServer(t)
+	envoy := &envoyRateLimitServer{rateLimiterServer: s}
+
+	// Act
+	_, err := envoy.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:      "down",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{envoyDescriptor("remote_address", "10.0.0.1")},
+	})
+
+	// Assert
+	assert.Equal(t, codes.Unavailable, status.Code(err), "Envoy should apply failure_mode_deny rather than see a denial")
+}
+
+func TestShouldRateLimit_FailedDescriptorInBatch(t *testing.T) {
+	// Arrange
+	s, reader := newTestServer(t, server.Policy{Name: "ips", Prefix: "ingress:remote_address=", Capacity: 2, RefillRate: 1})
+	envoy := &envoyRateLimitServer{rateLimiterServer: s}
+	ctx := context.Background()
+	req := &rlsv3.RateLimitRequest{
+		Domain: "ingress",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{
+			envoyDescriptor("remote_address", "10.0.0.1"),
+			envoyDescriptor("down", "", "remote_address", "10.0.0.2"),
+		},
+	}
+
+	// Act
+	_, firstErr := envoy.ShouldRateLimit(ctx, req)
+	_, secondErr := envoy.ShouldRateLimit(ctx, req)
+	third, thirdErr := envoy.ShouldRateLimit(ctx, req)
+
+	// Assert
+	assert.Equal(t, codes.Unavailable, status.Code(firstErr), "The outcome is unknown while no descriptor is over the limit")
+	assert.Equal(t, codes.Unavailable, status.Code(secondErr))
+	assert.Equal(t, int64(2), counterValue(t, reader, "rate_limiter_requests_total",
+		attribute.String("key", "ingress:remote_address=10.0.0.1"), attribute.Bool("allowed", true)),
+		"The checked descriptor's results should be recorded even though the request failed")
+	assert.Equal(t, int64(3), counterValue(t, reader, "rate_limiter_errors_total",
+		attribute.String("key", "ingress:down:remote_address=10.0.0.2"), attribute.String("reason", "backend_error")))
+
+	require.NoError(t, thirdErr, "A request over the limit should be denied despite the failed descriptor")
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, third.OverallCode, "The failed requests should have consumed tokens")
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, third.Statuses[0].Code)
+	assert.Equal(t, &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_UNKNOWN}, third.Statuses[1])
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	pb "github.com/carteralbrecht/rate-limiter/proto"
+	"github.com/carteralbrecht/rate-limiter/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+const (
+	// errorDomain is the ErrorInfo domain of errors returned by the rate limiter.
+	errorDomain = "ratelimiter"
+
+	// reasonBackendUnavailable is the ErrorInfo reason for backend failures.
+	reasonBackendUnavailable = "BACKEND_UNAVAILABLE"
+)
+
+// handlerError records metrics for an error returned by the rate limiter and converts it to a gRPC status error.
+// Backend failures are counted with reason=backend_error, separately from denials.
+func (s *rateLimiterServer) handlerError(ctx context.Context, key string, err error) error {
+	st := statusError(err)
+
+	reason := "internal_error"
+	switch status.Code(st) {
+	case codes.Unavailable:
+		reason = "backend_error"
+	case codes.InvalidArgument:
+		reason = "invalid_argument"
+	case codes.Canceled:
+		reason = "canceled"
+	case codes.DeadlineExceeded:
+		reason = "deadline_exceeded"
+	}
+	s.errors.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("key", key),
+			attribute.String("reason", reason),
+		),
+	)
+
+	return st
+}
+
+// statusError converts an error returned by the rate limiter to a gRPC status error. Backend failures map to
+// Unavailable with an ErrorInfo detail, so clients can tell them apart from denials and retry; policies that do not
+// support an operation map to InvalidArgument with a BadRequest detail naming the key.
+func statusError(err error) error {
+	var (
+		backendErr   *server.BackendError
+		algorithmErr *server.AlgorithmError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.As(err, &backendErr):
+		return withDetails(status.New(codes.Unavailable, err.Error()), &errdetails.ErrorInfo{
+			Reason:   reasonBackendUnavailable,
+			Domain:   errorDomain,
+			Metadata: map[string]string{"op": backendErr.Op, "key": backendErr.Key},
+		})
+	case errors.As(err, &algorithmErr):
+		return withDetails(status.New(codes.InvalidArgument, err.Error()), &errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+				Field:       "key",
+				Description: fmt.Sprintf("%s: policy %s uses %s, which does not support %s", algorithmErr.Key, algorithmErr.Policy, algorithmErr.Algorithm, algorithmErr.Op),
+			}},
+		})
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// checkError converts a gRPC status error to the error reported for a single key by CheckLimits and
+// CheckLimitStream.
+func checkError(err error) *pb.CheckError {
+	st := status.Convert(err).Proto()
+	return &pb.CheckError{Code: st.Code, Message: st.Message, Details: st.Details}
+}
+
+// withDetails attaches details to st, falling back to st alone if they cannot be marshaled.
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		log.Printf("Failed to attach error details: %v", err)
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// errorKey returns the key an error returned by the rate limiter is about, or "" if it is not about one key.
+func errorKey(err error) string {
+	var (
+		backendErr   *server.BackendError
+		algorithmErr *server.AlgorithmError
+	)
+	switch {
+	case errors.As(err, &backendErr):
+		return backendErr.Key
+	case errors.As(err, &algorithmErr):
+		return algorithmErr.Key
+	}
+	return ""
+}
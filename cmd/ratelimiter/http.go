@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxHTTPBodyBytes caps the size of JSON request bodies.
+const maxHTTPBodyBytes = 1 << 20
+
+// Timeouts of the HTTP gateway. Requests and responses are small, so clients that send or read them slowly are cut
+// off rather than holding connections open.
+const (
+	httpReadHeaderTimeout = 5 * time.Second
+	httpReadTimeout       = 10 * time.Second
+	httpWriteTimeout      = 10 * time.Second
+	httpIdleTimeout       = 2 * time.Minute
+)
+
+var (
+	// Accept both proto and JSON field names and ignore unknown fields, so clients are not broken by new fields
+	httpUnmarshal = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+	// Emit every field, including false and zero values, using the field names from the proto definitions. Unset
+	// messages such as a check's error are left out rather than emitted as null.
+	httpMarshal = protojson.MarshalOptions{UseProtoNames: true, EmitDefaultValues: true}
+)
+
+// newHTTPServer returns the HTTP/JSON gateway for s, listening on addr.
+func newHTTPServer(addr string, s *rateLimiterServer) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           newHTTPHandler(s),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+}
+
+// newHTTPHandler exposes the gRPC handlers as JSON over HTTP for clients that cannot speak gRPC. Request and
+// response bodies mirror the protobuf messages, and every call goes through the same handler and metrics as its
+// gRPC counterpart.
+func newHTTPHandler(s *rateLimiterServer) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("POST /v1/check", jsonHandler(s.CheckLimit))
+	mux.Handle("POST /v1/refill", jsonHandler(s.RefillBucket))
+	return mux
+}
+
+// jsonHandler adapts a unary gRPC handler to HTTP, decoding the request body into Req and encoding the response.
+func jsonHandler[Req any, Resp proto.Message, PReq interface {
+	*Req
+	proto.Message
+}](handle func(context.Context, PReq) (Resp, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPBodyBytes))
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, "failed to read request body: "+err.Error())
+			return
+		}
+
+		req := PReq(new(Req))
+		if err := httpUnmarshal.Unmarshal(body, req); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+
+		resp, err := handle(r.Context(), req)
+		if err != nil {
+			st := status.Convert(err)
+			writeHTTPError(w, httpStatus(st.Code()), st.Message())
+			return
+		}
+
+		out, err := httpMarshal.Marshal(resp)
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, "failed to encode response: "+err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(out); err != nil {
+			log.Printf("Failed to write HTTP response for %s: %v", r.URL.Path, err)
+		}
+	})
+}
+
+// httpStatus maps a gRPC status code returned by a handler to the closest HTTP status.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Canceled:
+		return 499 // Client closed request
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeHTTPError writes a JSON error body of the form {"error": message}.
+func writeHTTPError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+// serveHTTP sends a request with body to handler and returns the recorded response.
+func serveHTTP(t *testing.T, handler http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+// decodeJSON decodes the JSON body of rec into a map.
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return body
+}
+
+func TestHTTPHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   map[string]any
+	}{
+		{
+			name:       "check with proto field names",
+			path:       "/v1/check",
+			body:       `{"key": "user:1", "token_cost": 3}`,
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]any{"allowed": true, "remaining": 7.0, "retry_after": "0s", "reset_after": "3s", "limit": 10.0},
+		},
+		{
+			name:       "check with JSON field names and unknown fields",
+			path:       "/v1/check",
+			body:       `{"key": "user:1", "tokenCost": 3, "priority": "high"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]any{"allowed": true, "remaining": 7.0, "retry_after": "0s", "reset_after": "3s", "limit": 10.0},
+		},
+		{
+			name:       "check that can never be allowed",
+			path:       "/v1/check",
+			body:       `{"key": "user:1", "token_cost": 11}`,
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]any{"allowed": false, "remaining": 10.0, "retry_after": "-0.000000001s", "reset_after": "0s", "limit": 10.0},
+		},
+		{
+			name:       "refill",
+			path:       "/v1/refill",
+			body:       `{"key": "user:1", "leak_rate": 5}`,
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]any{"current_tokens": 10.0},
+		},
+		{
+			name:       "backend failure",
+			path:       "/v1/check",
+			body:       `{"key": "down:1", "token_cost": 1}`,
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   map[string]any{"error": "CheckAndConsumeTokens down:1: backend error: connection refused"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			s, _ := newTestServer(t)
+
+			// Act
+			rec := serveHTTP(t, newHTTPHandler(s), http.MethodPost, tt.path, tt.body)
+
+			// Assert
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantBody, decodeJSON(t, rec))
+		})
+	}
+}
+
+func TestHTTPHandler_RejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"malformed JSON", `{"key": `, http.StatusBadRequest, "invalid request body: "},
+		{"wrong field type", `{"key": 5}`, http.StatusBadRequest, "invalid request body: "},
+		{"body too large", `{"key": "` + strings.Repeat("a", maxHTTPBodyBytes) + `"}`, http.StatusBadRequest, "failed to read request body: "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			s, _ := newTestServer(t)
+
+			// Act
+			rec := serveHTTP(t, newHTTPHandler(s), http.MethodPost, "/v1/check", tt.body)
+
+			// Assert
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.True(t, strings.HasPrefix(decodeJSON(t, rec)["error"].(string), tt.wantError))
+		})
+	}
+}
+
+func TestHTTPHandler_OnlyAcceptsPost(t *testing.T) {
+	// Arrange
+	s, _ := newTestServer(t)
+
+	// Act
+	rec := serveHTTP(t, newHTTPHandler(s), http.MethodGet, "/v1/check", "")
+
+	// Assert
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want int
+	}{
+		{codes.InvalidArgument, http.StatusBadRequest},
+		{codes.FailedPrecondition, http.StatusBadRequest},
+		{codes.OutOfRange, http.StatusBadRequest},
+		{codes.NotFound, http.StatusNotFound},
+		{codes.ResourceExhausted, http.StatusTooManyRequests},
+		{codes.Unavailable, http.StatusServiceUnavailable},
+		{codes.DeadlineExceeded, http.StatusGatewayTimeout},
+		{codes.Canceled, 499},
+		{codes.Internal, http.StatusInternalServerError},
+		{codes.Unknown, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			assert.Equal(t, tt.want, httpStatus(tt.code))
+		})
+	}
+}
+
+func TestNewHTTPServer_SetsTimeouts(t *testing.T) {
+	// Arrange
+	s, _ := newTestServer(t)
+
+	// Act
+	srv := newHTTPServer(":8080", s)
+
+	// Assert
+	assert.Equal(t, ":8080", srv.Addr)
+	assert.NotNil(t, srv.Handler)
+	assert.Equal(t, httpReadHeaderTimeout, srv.ReadHeaderTimeout, "Slow clients should not hold connections open")
+	assert.Equal(t, httpReadTimeout, srv.ReadTimeout)
+	assert.Equal(t, httpWriteTimeout, srv.WriteTimeout)
+	assert.Equal(t, httpIdleTimeout, srv.IdleTimeout)
+}
@@ -4,20 +4,28 @@ import (
 	"context"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
-	"github.com/carteralbrecht/rate-limiter/internal/server"
 	pb "github.com/carteralbrecht/rate-limiter/proto"
-	"github.com/redis/go-redis/v9"
+	"github.com/carteralbrecht/rate-limiter/server"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/metric"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+// shutdownTimeout is how long in-flight calls get to finish after SIGINT or SIGTERM before they are cut off.
+const shutdownTimeout = 10 * time.Second
+
 type rateLimiterServer struct {
 	pb.UnimplementedRateLimiterServer
 	rateLimiter *server.RateLimiter
@@ -26,10 +34,11 @@ type rateLimiterServer struct {
 	remaining   metric.Int64UpDownCounter
 	duration    metric.Float64Histogram
 	errors      metric.Int64Counter
+	leasesInUse metric.Int64Gauge
 }
 
 // NewRateLimiterServer creates a new instance of rateLimiterServer with dependency injection.
-func NewRateLimiterServer(redisClient *redis.Client, meter metric.Meter) *rateLimiterServer {
+func NewRateLimiterServer(rateLimiter *server.RateLimiter, meter metric.Meter) *rateLimiterServer {
 	requests, _ := meter.Int64Counter(
 		"rate_limiter_requests_total",
 		metric.WithDescription("Total number of rate limiter requests"),
@@ -52,13 +61,33 @@ func NewRateLimiterServer(redisClient *redis.Client, meter metric.Meter) *rateLi
 		metric.WithDescription("Total number of rate limiter errors"),
 	)
 
+	leasesInUse, _ := meter.Int64Gauge(
+		"rate_limiter_leases_in_use",
+		metric.WithDescription("Number of concurrency leases currently held"),
+	)
+
+	// Buckets expire once they are full again, so this tracks how many keys are actively being limited
+	_, _ = meter.Int64ObservableGauge(
+		"rate_limiter_buckets",
+		metric.WithDescription("Number of live buckets holding limiter state"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			count, err := rateLimiter.BucketCount(ctx)
+			if err != nil {
+				return err
+			}
+			o.Observe(count)
+			return nil
+		}),
+	)
+
 	return &rateLimiterServer{
-		rateLimiter: server.NewRateLimiter(redisClient),
+		rateLimiter: rateLimiter,
 		meter:       meter,
 		requests:    requests,
 		remaining:   remaining,
 		duration:    duration,
 		errors:      errors,
+		leasesInUse: leasesInUse,
 	}
 }
 
@@ -74,35 +103,105 @@ func (s *rateLimiterServer) CheckLimit(ctx context.Context, req *pb.CheckRequest
 		)
 	}()
 
-	allowed, remaining := s.rateLimiter.CheckAndConsumeTokens(ctx, req.Key, int(req.TokenCost))
+	result, err := s.rateLimiter.CheckAndConsumeTokens(ctx, req.Key, int(req.TokenCost))
+	if err != nil {
+		return nil, s.handlerError(ctx, req.Key, err)
+	}
+	return s.checkResponse(ctx, req.Key, result), nil
+}
+
+func (s *rateLimiterServer) CheckLimits(ctx context.Context, req *pb.CheckLimitsRequest) (*pb.CheckLimitsResponse, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Seconds()
+		log.Printf("Request duration for %d descriptors: %.6f seconds", len(req.Descriptors), duration)
+		s.duration.Record(ctx, duration,
+			metric.WithAttributes(
+				attribute.Int("descriptors", len(req.Descriptors)),
+			),
+		)
+	}()
+
+	descriptors := make([]server.Descriptor, len(req.Descriptors))
+	for i, d := range req.Descriptors {
+		descriptors[i] = server.Descriptor{Key: d.Key, TokenCost: int(d.TokenCost)}
+	}
+
+	// An all-or-nothing check either consumes from every key or none, so it fails as a whole
+	var (
+		results []server.Result
+		errs    []error
+	)
+	if req.AllOrNothing {
+		var err error
+		results, err = s.rateLimiter.CheckAndConsumeTokensAllOrNothing(ctx, descriptors)
+		if err != nil {
+			return nil, s.handlerError(ctx, errorKey(err), err)
+		}
+		errs = make([]error, len(results))
+	} else {
+		results, errs = s.rateLimiter.CheckAndConsumeTokensBatch(ctx, descriptors)
+	}
+
+	// Descriptors that were checked have already consumed tokens, so one that failed must not fail the others
+	resp := &pb.CheckLimitsResponse{Allowed: true}
+	for i, result := range results {
+		key := descriptors[i].Key
+		if errs[i] != nil {
+			resp.Results = append(resp.Results, &pb.CheckResponse{Error: checkError(s.handlerError(ctx, key, errs[i]))})
+			resp.Allowed = false
+			continue
+		}
+		resp.Results = append(resp.Results, s.checkResponse(ctx, key, result))
+		resp.Allowed = resp.Allowed && result.Allowed
+	}
+	return resp, nil
+}
+
+// checkResponse records metrics for the result of checking key and converts it to a response.
+func (s *rateLimiterServer) checkResponse(ctx context.Context, key string, result server.Result) *pb.CheckResponse {
+	s.recordResult(ctx, key, result)
 
+	return &pb.CheckResponse{
+		Allowed:    result.Allowed,
+		Remaining:  int32(result.Remaining),
+		RetryAfter: durationpb.New(result.RetryAfter),
+		ResetAfter: durationpb.New(result.ResetAfter),
+		Limit:      int32(result.Limit),
+	}
+}
+
+// recordResult records metrics for the result of checking key.
+func (s *rateLimiterServer) recordResult(ctx context.Context, key string, result server.Result) {
 	s.requests.Add(ctx, 1,
 		metric.WithAttributes(
-			attribute.String("key", req.Key),
-			attribute.Bool("allowed", allowed),
+			attribute.String("key", key),
+			attribute.Bool("allowed", result.Allowed),
 		),
 	)
 
-	s.remaining.Add(ctx, int64(remaining),
+	s.remaining.Add(ctx, int64(result.Remaining),
 		metric.WithAttributes(
-			attribute.String("key", req.Key),
+			attribute.String("key", key),
 		),
 	)
 
-	if !allowed {
+	if !result.Allowed {
 		s.errors.Add(ctx, 1,
 			metric.WithAttributes(
-				attribute.String("key", req.Key),
+				attribute.String("key", key),
 				attribute.String("reason", "rate_limited"),
 			),
 		)
 	}
-
-	return &pb.CheckResponse{Allowed: allowed, Remaining: int32(remaining)}, nil
 }
 
 func (s *rateLimiterServer) RefillBucket(ctx context.Context, req *pb.RefillRequest) (*pb.RefillResponse, error) {
-	currentTokens := s.rateLimiter.RefillTokens(ctx, req.Key, int(req.LeakRate), int(req.BucketSize))
+	// The bucket size always comes from the key's policy, so req.BucketSize is ignored
+	currentTokens, err := s.rateLimiter.RefillTokens(ctx, req.Key, int(req.LeakRate))
+	if err != nil {
+		return nil, s.handlerError(ctx, req.Key, err)
+	}
 
 	s.remaining.Add(ctx, int64(currentTokens),
 		metric.WithAttributes(
@@ -113,6 +212,52 @@ func (s *rateLimiterServer) RefillBucket(ctx context.Context, req *pb.RefillRequ
 	return &pb.RefillResponse{CurrentTokens: int32(currentTokens)}, nil
 }
 
+func (s *rateLimiterServer) AcquireLease(ctx context.Context, req *pb.AcquireLeaseRequest) (*pb.AcquireLeaseResponse, error) {
+	lease, err := s.rateLimiter.AcquireLease(ctx, req.Key)
+	if err != nil {
+		return nil, s.handlerError(ctx, req.Key, err)
+	}
+
+	s.leasesInUse.Record(ctx, int64(lease.InUse),
+		metric.WithAttributes(
+			attribute.String("key", req.Key),
+		),
+	)
+
+	if !lease.Acquired {
+		s.errors.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("key", req.Key),
+				attribute.String("reason", "concurrency_limited"),
+			),
+		)
+		return &pb.AcquireLeaseResponse{InUse: int32(lease.InUse), Limit: int32(lease.Limit)}, nil
+	}
+
+	return &pb.AcquireLeaseResponse{
+		Acquired:        true,
+		LeaseId:         lease.ID,
+		InUse:           int32(lease.InUse),
+		Limit:           int32(lease.Limit),
+		ExpiresAtUnixMs: lease.ExpiresAt.UnixMilli(),
+	}, nil
+}
+
+func (s *rateLimiterServer) ReleaseLease(ctx context.Context, req *pb.ReleaseLeaseRequest) (*pb.ReleaseLeaseResponse, error) {
+	released, inUse, err := s.rateLimiter.ReleaseLease(ctx, req.Key, req.LeaseId)
+	if err != nil {
+		return nil, s.handlerError(ctx, req.Key, err)
+	}
+
+	s.leasesInUse.Record(ctx, int64(inUse),
+		metric.WithAttributes(
+			attribute.String("key", req.Key),
+		),
+	)
+
+	return &pb.ReleaseLeaseResponse{Released: released, InUse: int32(inUse)}, nil
+}
+
 func initMeter() (metric.Meter, func(), error) {
 	ctx := context.Background()
 
@@ -157,26 +302,77 @@ func main() {
 	}
 	defer shutdown()
 
-	// Get Redis address from environment variable or use default
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379"
+	// Load limit policies from POLICY_FILE, or apply the default policy to every key
+	policyFile := os.Getenv("POLICY_FILE")
+	policies := server.DefaultPolicies()
+	if policyFile != "" {
+		policies, err = server.LoadPolicies(policyFile)
+		if err != nil {
+			log.Fatalf("Failed to load policies: %v", err)
+		}
+		log.Printf("Loaded %d policies from %s", policies.Len(), policyFile)
 	}
 
-	// Create Redis client
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
+	// Stop serving on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Keep limiter state in Redis unless STORAGE_BACKEND selects process memory
+	var backend, fallback server.Backend
+	switch storage := os.Getenv("STORAGE_BACKEND"); storage {
+	case "", "redis":
+		// Create a standalone, cluster or sentinel Redis client depending on REDIS_MODE
+		redisClient, target, err := newRedisClient()
+		if err != nil {
+			log.Fatalf("Invalid Redis configuration: %v", err)
+		}
+
+		// Test Redis connection
+		if err := redisClient.Ping(ctx).Err(); err != nil {
+			log.Fatalf("Failed to connect to %s: %v", target, err)
+		}
+		log.Printf("Connected to %s", target)
 
-	// Test Redis connection
-	ctx := context.Background()
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		// Fail fast once Redis is down, so policies' failure modes apply without waiting for timeouts
+		breaker, err := newCircuitBreaker(meter)
+		if err != nil {
+			log.Fatalf("Invalid circuit breaker configuration: %v", err)
+		}
+		redisClient.AddHook(breaker)
+		backend = server.NewRedisBackend(redisClient)
+
+		// Enforce policies with failure_mode: local in memory while Redis is down
+		memory := server.NewMemoryBackend(server.MemoryOptions{})
+		defer memory.Close()
+		fallback = memory
+	case "memory":
+		memory := server.NewMemoryBackend(server.MemoryOptions{})
+		defer memory.Close()
+		log.Printf("Keeping rate limiter state in memory; limits are not shared with other instances")
+		backend = memory
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, expected redis or memory", storage)
 	}
-	log.Printf("Connected to Redis at %s", redisAddr)
 
-	// Create a new rateLimiterServer instance with the injected Redis client and meter
-	server := NewRateLimiterServer(redisClient, meter)
+	// Create a new rateLimiterServer instance with the injected backend, policies and meter
+	rateLimiter := server.NewRateLimiterWithBackend(backend, policies)
+	if fallback != nil {
+		// Each instance enforces its share of the limits while Redis is down
+		nodes := 1
+		if n := os.Getenv("FALLBACK_NODES"); n != "" {
+			nodes, err = strconv.Atoi(n)
+			if err != nil || nodes <= 0 {
+				log.Fatalf("FALLBACK_NODES must be a positive integer, got %q", n)
+			}
+		}
+		rateLimiter.SetFallback(fallback, nodes)
+	}
+	server := NewRateLimiterServer(rateLimiter, meter)
+
+	// Watch the policy file so limits can change without a restart
+	if policyFile != "" {
+		go newPolicyReloader(policyFile, server.rateLimiter, meter).Run(ctx)
+	}
 
 	// Set up gRPC server
 	lis, err := net.Listen("tcp", ":50051")
@@ -184,9 +380,69 @@ func main() {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
+	// Serve the same API as JSON over HTTP for clients that cannot speak gRPC
+	httpAddr := os.Getenv("HTTP_ADDR")
+	if httpAddr == "" {
+		httpAddr = ":8080"
+	}
+	httpServer := newHTTPServer(httpAddr, server)
+	go func() {
+		log.Printf("HTTP server running on %s", httpAddr)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to serve HTTP: %v", err)
+		}
+	}()
+
 	grpcServer := grpc.NewServer()
 	pb.RegisterRateLimiterServer(grpcServer, server)
 
+	// Also serve Envoy's global rate limit service API so Envoy can call the rate limiter directly
+	rlsv3.RegisterRateLimitServiceServer(grpcServer, &envoyRateLimitServer{rateLimiterServer: server})
+
+	// Serve the admin API only on ADMIN_ADDR, so it is never exposed on the port clients reach
+	var adminGRPCServer *grpc.Server
+	if adminAddr := os.Getenv("ADMIN_ADDR"); adminAddr != "" {
+		adminLis, err := net.Listen("tcp", adminAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for admin API: %v", err)
+		}
+		adminGRPCServer = grpc.NewServer()
+		pb.RegisterRateLimiterAdminServer(adminGRPCServer, &adminServer{rateLimiter: rateLimiter})
+		go func() {
+			log.Printf("Admin gRPC server running on %s", adminAddr)
+			if err := adminGRPCServer.Serve(adminLis); err != nil {
+				log.Fatalf("Failed to serve admin API: %v", err)
+			}
+		}()
+	} else {
+		log.Println("Admin API disabled, set ADMIN_ADDR to serve it")
+	}
+
+	// Stop every server together, giving in-flight calls up to shutdownTimeout to finish
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down HTTP server: %v", err)
+		}
+		if adminGRPCServer != nil {
+			adminGRPCServer.Stop()
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+		}
+	}()
+
 	log.Println("gRPC server running on port 50051")
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("Failed to serve: %v", err)
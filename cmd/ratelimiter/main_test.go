@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	pb "github.com/carteralbrecht/rate-limiter/proto"
+	"github.com/carteralbrecht/rate-limiter/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+)
+
+// downBackend keeps limiter state in memory, except that every check of a key containing "down:" fails as if Redis
+// were unreachable.
+type downBackend struct {
+	*server.MemoryBackend
+}
+
+func (b downBackend) Check(ctx context.Context, check server.Check) (server.Result, error) {
+	if strings.Contains(check.Key, "down:") {
+		return server.Result{}, errors.New("connection refused")
+	}
+	return b.MemoryBackend.Check(ctx, check)
+}
+
+func (b downBackend) CheckBatch(ctx context.Context, checks []server.Check) ([]server.Result, []error) {
+	results, errs := b.MemoryBackend.CheckBatch(ctx, checks)
+	for i, c := range checks {
+		if strings.Contains(c.Key, "down:") {
+			results[i], errs[i] = server.Result{}, errors.New("connection refused")
+		}
+	}
+	return results, errs
+}
+
+// newTestServer returns a server with the given policies besides the default one, whose backend fails for keys
+// containing "down:".
+func newTestServer(t *testing.T, policies ...server.Policy) (*rateLimiterServer, *sdkmetric.ManualReader) {
+	t.Helper()
+	set, err := server.NewPolicySet(server.DefaultPolicy(), policies)
+	require.NoError(t, err)
+	memory := server.NewMemoryBackend(server.MemoryOptions{})
+	t.Cleanup(func() { memory.Close() })
+	meter, reader := newTestMeter(t)
+	rateLimiter := server.NewRateLimiterWithBackend(downBackend{memory}, set)
+	return NewRateLimiterServer(rateLimiter, meter), reader
+}
+
+func TestCheckLimits_ReportsFailedDescriptors(t *testing.T) {
+	// Arrange
+	s, reader := newTestServer(t)
+	ctx := context.Background()
+	req := &pb.CheckLimitsRequest{Descriptors: []*pb.CheckRequest{
+		{Key: "user:1", TokenCost: 1},
+		{Key: "down:1", TokenCost: 1},
+	}}
+
+	// Act
+	first, err := s.CheckLimits(ctx, req)
+	require.NoError(t, err, "A failure for one descriptor should not fail the call")
+	second, err := s.CheckLimits(ctx, req)
+	require.NoError(t, err)
+
+	// Assert
+	assert.False(t, first.Allowed, "A descriptor that could not be checked should not be allowed")
+	assert.True(t, first.Results[0].Allowed)
+	assert.Nil(t, first.Results[0].Error)
+	assert.Equal(t, int32(9), first.Results[0].Remaining)
+	assert.Equal(t, int32(8), second.Results[0].Remaining, "The descriptor that was checked should have consumed tokens")
+
+	checkErr := first.Results[1].Error
+	require.NotNil(t, checkErr)
+	assert.Equal(t, int32(codes.Unavailable), checkErr.Code)
+	require.Len(t, checkErr.Details, 1)
+	var info errdetails.ErrorInfo
+	require.NoError(t, checkErr.Details[0].UnmarshalTo(&info))
+	assert.Equal(t, reasonBackendUnavailable, info.Reason)
+	assert.Equal(t, "down:1", info.Metadata["key"])
+	assert.Equal(t, int64(2), counterValue(t, reader, "rate_limiter_errors_total",
+		attribute.String("key", "down:1"), attribute.String("reason", "backend_error")))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carteralbrecht/rate-limiter/server"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// newRedisClient creates a Redis client from the environment:
+//
+//   - REDIS_MODE selects "standalone" (the default), "cluster" or "sentinel".
+//   - REDIS_ADDRS is a comma-separated list of addresses: the cluster seed nodes in cluster mode and the
+//     sentinels in sentinel mode. REDIS_ADDR is used if it is not set.
+//   - REDIS_MASTER_NAME is the name of the master monitored by the sentinels, required in sentinel mode.
+//   - REDIS_PASSWORD and REDIS_SENTINEL_PASSWORD authenticate to Redis and to the sentinels.
+func newRedisClient() (redis.UniversalClient, string, error) {
+	addrs := os.Getenv("REDIS_ADDRS")
+	if addrs == "" {
+		addrs = os.Getenv("REDIS_ADDR")
+	}
+	if addrs == "" {
+		addrs = "localhost:6379"
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:            strings.Split(addrs, ","),
+		MasterName:       os.Getenv("REDIS_MASTER_NAME"),
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+	}
+
+	switch mode := os.Getenv("REDIS_MODE"); mode {
+	case "", "standalone":
+		if len(opts.Addrs) > 1 {
+			return nil, "", fmt.Errorf("standalone mode takes a single address, got %d; set REDIS_MODE=cluster or sentinel", len(opts.Addrs))
+		}
+		return redis.NewClient(opts.Simple()), fmt.Sprintf("Redis at %s", addrs), nil
+	case "cluster":
+		return redis.NewClusterClient(opts.Cluster()), fmt.Sprintf("Redis Cluster via %s", addrs), nil
+	case "sentinel":
+		if opts.MasterName == "" {
+			return nil, "", fmt.Errorf("REDIS_MASTER_NAME is required in sentinel mode")
+		}
+		return redis.NewFailoverClient(opts.Failover()), fmt.Sprintf("Redis master %s via sentinels %s", opts.MasterName, addrs), nil
+	default:
+		return nil, "", fmt.Errorf("unknown REDIS_MODE %q, expected standalone, cluster or sentinel", mode)
+	}
+}
+
+// newCircuitBreaker creates the circuit breaker guarding the Redis client from the environment, and reports its
+// state as the rate_limiter_circuit_breaker_state gauge:
+//
+//   - CIRCUIT_BREAKER_THRESHOLD is the number of consecutive failed commands that opens the circuit.
+//   - CIRCUIT_BREAKER_TIMEOUT is how long the circuit stays open before a probe command is sent, e.g. "5s".
+func newCircuitBreaker(meter metric.Meter) (*server.CircuitBreaker, error) {
+	var opts server.CircuitBreakerOptions
+	if threshold := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); threshold != "" {
+		n, err := strconv.Atoi(threshold)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("CIRCUIT_BREAKER_THRESHOLD must be a positive integer, got %q", threshold)
+		}
+		opts.FailureThreshold = n
+	}
+	if timeout := os.Getenv("CIRCUIT_BREAKER_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("CIRCUIT_BREAKER_TIMEOUT must be a positive duration, got %q", timeout)
+		}
+		opts.OpenTimeout = d
+	}
+	breaker := server.NewCircuitBreaker(opts)
+
+	_, err := meter.Int64ObservableGauge(
+		"rate_limiter_circuit_breaker_state",
+		metric.WithDescription("State of the Redis circuit breaker: 0 closed, 1 half-open, 2 open"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(breaker.State()))
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return breaker, nil
+}
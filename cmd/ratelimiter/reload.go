@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/carteralbrecht/rate-limiter/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// policyPollInterval is how often the policy file is checked for changes.
+const policyPollInterval = 2 * time.Second
+
+// policyReloader keeps a RateLimiter's policies in sync with a policy file. It reloads the file when its
+// modification time or size changes and whenever the process receives SIGHUP.
+type policyReloader struct {
+	path        string
+	rateLimiter *server.RateLimiter
+	reloads     metric.Int64Counter
+
+	modTime time.Time
+	size    int64
+}
+
+func newPolicyReloader(path string, rateLimiter *server.RateLimiter, meter metric.Meter) *policyReloader {
+	reloads, _ := meter.Int64Counter(
+		"rate_limiter_policy_reloads_total",
+		metric.WithDescription("Total number of policy file reload attempts"),
+	)
+
+	r := &policyReloader{
+		path:        path,
+		rateLimiter: rateLimiter,
+		reloads:     reloads,
+	}
+
+	// Remember the file that was loaded at startup so it is not immediately reloaded
+	if info, err := os.Stat(path); err == nil {
+		r.modTime, r.size = info.ModTime(), info.Size()
+	}
+
+	return r
+}
+
+// Run watches for changes until ctx is cancelled.
+func (r *policyReloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(policyPollInterval)
+	defer ticker.Stop()
+
+	r.run(ctx, hup, ticker.C)
+}
+
+// run reloads the policies whenever hup receives a signal, and whenever tick fires and the file has changed, until
+// ctx is cancelled.
+func (r *policyReloader) run(ctx context.Context, hup <-chan os.Signal, tick <-chan time.Time) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("Received SIGHUP, reloading policies from %s", r.path)
+			r.reload(ctx)
+		case <-tick:
+			if r.changed() {
+				log.Printf("Policy file %s changed, reloading policies", r.path)
+				r.reload(ctx)
+			}
+		}
+	}
+}
+
+func (r *policyReloader) changed() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		// A missing file is reported when the reload is attempted, and editors briefly remove files while saving
+		return false
+	}
+	return !info.ModTime().Equal(r.modTime) || info.Size() != r.size
+}
+
+// reload loads the policy file and swaps it in. On failure the current policies stay active.
+func (r *policyReloader) reload(ctx context.Context) {
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime, r.size = info.ModTime(), info.Size()
+	}
+
+	policies, err := server.LoadPolicies(r.path)
+	if err != nil {
+		log.Printf("Failed to reload policies, keeping current policies: %v", err)
+		r.reloads.Add(ctx, 1, metric.WithAttributes(attribute.String("result", "failure")))
+		return
+	}
+
+	r.rateLimiter.SetPolicies(policies)
+	log.Printf("Reloaded %d policies from %s", policies.Len(), r.path)
+	r.reloads.Add(ctx, 1, metric.WithAttributes(attribute.String("result", "success")))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/carteralbrecht/rate-limiter/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// newTestMeter returns a meter whose measurements can be read back from the returned reader.
+func newTestMeter(t *testing.T) (metric.Meter, *sdkmetric.ManualReader) {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return provider.Meter("test"), reader
+}
+
+// counterValue returns the value of the data point of the named Int64 counter with the given attributes, or zero
+// if nothing was recorded for them.
+func counterValue(t *testing.T, reader *sdkmetric.ManualReader, name string, attrs ...attribute.KeyValue) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+
+	want := attribute.NewSet(attrs...)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			require.True(t, ok, "%s should be an Int64 counter", name)
+			for _, dp := range sum.DataPoints {
+				if dp.Attributes.Equals(&want) {
+					return dp.Value
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// writePolicyFile replaces the policy file at path with contents.
+func writePolicyFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+}
+
+// startReloader loads the policy file at path and runs a reloader for it with the returned channels standing in
+// for SIGHUP and the poll ticker. The channels are unbuffered, so once a send returns, every event sent before it
+// has been handled.
+func startReloader(t *testing.T, path string) (*server.RateLimiter, *sdkmetric.ManualReader, chan os.Signal, chan time.Time) {
+	t.Helper()
+	policies, err := server.LoadPolicies(path)
+	require.NoError(t, err)
+	backend := server.NewMemoryBackend(server.MemoryOptions{})
+	t.Cleanup(func() { backend.Close() })
+	rateLimiter := server.NewRateLimiterWithBackend(backend, policies)
+
+	meter, reader := newTestMeter(t)
+	reloader := newPolicyReloader(path, rateLimiter, meter)
+	hup, tick := make(chan os.Signal), make(chan time.Time)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reloader.run(ctx, hup, tick)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return rateLimiter, reader, hup, tick
+}
+
+func TestPolicyReloader_ReloadsChangedFile(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	writePolicyFile(t, path, "default:\n  capacity: 10\n  refill_rate: 1\n")
+	rateLimiter, reader, _, tick := startReloader(t, path)
+
+	// Act
+	tick <- time.Now()
+	unchanged := rateLimiter.Policies().Match("user:1").Capacity
+	writePolicyFile(t, path, "default:\n  capacity: 250\n  refill_rate: 1\n")
+	tick <- time.Now()
+	tick <- time.Now()
+
+	// Assert
+	assert.Equal(t, 10, unchanged)
+	assert.Equal(t, 250, rateLimiter.Policies().Match("user:1").Capacity, "The changed file should be loaded")
+	assert.Equal(t, int64(1), counterValue(t, reader, "rate_limiter_policy_reloads_total", attribute.String("result", "success")),
+		"An unchanged file should not be reloaded")
+}
+
+func TestPolicyReloader_KeepsPoliciesOnInvalidFile(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	writePolicyFile(t, path, "default:\n  capacity: 10\n  refill_rate: 1\n")
+	rateLimiter, reader, _, tick := startReloader(t, path)
+
+	// Act
+	writePolicyFile(t, path, "default:\n  capacity: -5\n  refill_rate: 1\n")
+	tick <- time.Now()
+	tick <- time.Now()
+
+	// Assert
+	assert.Equal(t, 10, rateLimiter.Policies().Match("user:1").Capacity, "The current policies should stay active")
+	assert.Equal(t, int64(1), counterValue(t, reader, "rate_limiter_policy_reloads_total", attribute.String("result", "failure")))
+	assert.Zero(t, counterValue(t, reader, "rate_limiter_policy_reloads_total", attribute.String("result", "success")))
+}
+
+func TestPolicyReloader_ReloadsOnSIGHUP(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	writePolicyFile(t, path, "default:\n  capacity: 10\n  refill_rate: 1\n")
+	rateLimiter, reader, hup, tick := startReloader(t, path)
+
+	// Keep the size and modification time, so only SIGHUP can notice the change
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	writePolicyFile(t, path, "default:\n  capacity: 20\n  refill_rate: 1\n")
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+	// Act
+	hup <- syscall.SIGHUP
+	hup <- syscall.SIGHUP
+	tick <- time.Now()
+
+	// Assert
+	assert.Equal(t, 20, rateLimiter.Policies().Match("user:1").Capacity)
+	assert.Equal(t, int64(2), counterValue(t, reader, "rate_limiter_policy_reloads_total", attribute.String("result", "success")),
+		"Every SIGHUP should reload the file, even if it has not changed")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/carteralbrecht/rate-limiter/proto"
+	"github.com/carteralbrecht/rate-limiter/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// streamBatchSize is the most checks from one stream sent to Redis in a single pipeline.
+	streamBatchSize = 256
+
+	// streamMaxInFlight is the most pipelines a single stream can have waiting on Redis at once.
+	streamMaxInFlight = 4
+)
+
+// CheckLimitStream checks keys sent over a bidirectional stream. Checks that are already queued when a pipeline
+// is sent are batched into it, so the batch size adapts to how fast the client is sending. Batches run
+// concurrently, so responses can arrive out of order and are matched to requests by request ID. A check that fails,
+// e.g. because the backend is down, is answered with the error CheckLimit would return and the stream stays open.
+// The stream only ends early if a response cannot be sent.
+func (s *rateLimiterServer) CheckLimitStream(stream pb.RateLimiter_CheckLimitStreamServer) error {
+	ctx := stream.Context()
+
+	requests := make(chan *pb.StreamCheckRequest, streamBatchSize)
+	done := make(chan struct{})
+	defer close(done)
+	var recvErr error
+	go func() {
+		defer close(requests)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					recvErr = err
+				}
+				return
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		sendMu   sync.Mutex
+		sendErr  error
+		failed   = make(chan struct{})
+		inFlight = make(chan struct{}, streamMaxInFlight)
+	)
+	for {
+		// Stop as soon as a response cannot be sent, rather than waiting for the client's next message
+		var req *pb.StreamCheckRequest
+		select {
+		case next, ok := <-requests:
+			if !ok {
+				wg.Wait()
+				if recvErr != nil {
+					log.Printf("CheckLimitStream: Stream closed: %v", recvErr)
+					return recvErr
+				}
+				return sendErr
+			}
+			req = next
+		case <-failed:
+			wg.Wait()
+			return sendErr
+		}
+
+		batch := []*pb.StreamCheckRequest{req}
+	drain:
+		for len(batch) < streamBatchSize {
+			select {
+			case next, ok := <-requests:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-inFlight
+				wg.Done()
+			}()
+
+			start := time.Now()
+			descriptors := make([]server.Descriptor, len(batch))
+			for i, req := range batch {
+				descriptors[i] = server.Descriptor{Key: req.GetCheck().GetKey(), TokenCost: int(req.GetCheck().GetTokenCost())}
+			}
+			results, errs := s.rateLimiter.CheckAndConsumeTokensBatch(ctx, descriptors)
+
+			responses := make([]*pb.StreamCheckResponse, len(batch))
+			for i, result := range results {
+				responses[i] = &pb.StreamCheckResponse{RequestId: batch[i].RequestId}
+				if errs[i] != nil {
+					responses[i].Result = &pb.CheckResponse{Error: checkError(s.handlerError(ctx, descriptors[i].Key, errs[i]))}
+				} else {
+					responses[i].Result = s.checkResponse(ctx, descriptors[i].Key, result)
+				}
+			}
+
+			// Send must not be called concurrently on the same stream
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			for _, resp := range responses {
+				if sendErr != nil {
+					break
+				}
+				if sendErr = stream.Send(resp); sendErr != nil {
+					close(failed)
+				}
+			}
+
+			s.duration.Record(ctx, time.Since(start).Seconds(),
+				metric.WithAttributes(
+					attribute.Int("descriptors", len(batch)),
+				),
+			)
+		}()
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	pb "github.com/carteralbrecht/rate-limiter/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// fakeCheckStream is the server side of a CheckLimitStream. Requests sent on requests are received in order, and
+// closing it ends the client's side of the stream. Sent responses are delivered on responses unless sendErr is set.
+type fakeCheckStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	requests  chan *pb.StreamCheckRequest
+	responses chan *pb.StreamCheckResponse
+	sendErr   error
+}
+
+func (s *fakeCheckStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeCheckStream) Recv() (*pb.StreamCheckRequest, error) {
+	select {
+	case req, ok := <-s.requests:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *fakeCheckStream) Send(resp *pb.StreamCheckResponse) error {
+	if s.sendErr != nil {
+		return s.sendErr
+	}
+	s.responses <- resp
+	return nil
+}
+
+// startCheckStream runs CheckLimitStream on a test server against a fake stream, and returns the stream along
+// with a channel that receives the handler's return value.
+func startCheckStream(t *testing.T, sendErr error) (*fakeCheckStream, <-chan error) {
+	t.Helper()
+	s, _ := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream := &fakeCheckStream{
+		ctx:       ctx,
+		requests:  make(chan *pb.StreamCheckRequest),
+		responses: make(chan *pb.StreamCheckResponse, 16),
+		sendErr:   sendErr,
+	}
+
+	returned := make(chan error, 1)
+	go func() { returned <- s.CheckLimitStream(stream) }()
+	return stream, returned
+}
+
+// receive returns the next response sent on stream, failing the test if none is sent in time.
+func receive(t *testing.T, stream *fakeCheckStream) *pb.StreamCheckResponse {
+	t.Helper()
+	select {
+	case resp := <-stream.responses:
+		return resp
+	case <-time.After(time.Second):
+		require.FailNow(t, "No response was sent")
+		return nil
+	}
+}
+
+func TestCheckLimitStream_AnswersFailedChecksAndStaysOpen(t *testing.T) {
+	// Arrange
+	stream, returned := startCheckStream(t, nil)
+
+	// Act
+	stream.requests <- &pb.StreamCheckRequest{RequestId: 1, Check: &pb.CheckRequest{Key: "down:1", TokenCost: 1}}
+	failed := receive(t, stream)
+	stream.requests <- &pb.StreamCheckRequest{RequestId: 2, Check: &pb.CheckRequest{Key: "user:1", TokenCost: 1}}
+	checked := receive(t, stream)
+	close(stream.requests)
+
+	// Assert
+	assert.Equal(t, uint64(1), failed.RequestId)
+	require.NotNil(t, failed.Result.Error, "A failed check should be answered with its error")
+	assert.Equal(t, int32(codes.Unavailable), failed.Result.Error.Code)
+	assert.False(t, failed.Result.Allowed)
+
+	assert.Equal(t, uint64(2), checked.RequestId)
+	assert.Nil(t, checked.Result.Error)
+	assert.True(t, checked.Result.Allowed, "The stream should keep serving checks after a failure")
+	assert.Equal(t, int32(9), checked.Result.Remaining)
+
+	select {
+	case err := <-returned:
+		assert.NoError(t, err, "The stream should end cleanly once the client closes it")
+	case <-time.After(time.Second):
+		assert.Fail(t, "The stream did not end")
+	}
+}
+
+func TestCheckLimitStream_BatchesQueuedChecks(t *testing.T) {
+	// Arrange
+	stream, returned := startCheckStream(t, nil)
+
+	// Act
+	for id := uint64(1); id <= 3; id++ {
+		stream.requests <- &pb.StreamCheckRequest{RequestId: id, Check: &pb.CheckRequest{Key: "user:1", TokenCost: 1}}
+	}
+	close(stream.requests)
+	require.NoError(t, <-returned)
+	close(stream.responses)
+
+	// Assert
+	var ids []uint64
+	var remaining []int32
+	for resp := range stream.responses {
+		ids = append(ids, resp.RequestId)
+		remaining = append(remaining, resp.Result.Remaining)
+	}
+	assert.ElementsMatch(t, []uint64{1, 2, 3}, ids, "Every request should be answered once")
+	assert.ElementsMatch(t, []int32{9, 8, 7}, remaining)
+}
+
+func TestCheckLimitStream_ReturnsWhenSendFails(t *testing.T) {
+	// Arrange
+	sendErr := errors.New("transport is closing")
+	stream, returned := startCheckStream(t, sendErr)
+
+	// Act
+	stream.requests <- &pb.StreamCheckRequest{RequestId: 1, Check: &pb.CheckRequest{Key: "user:1", TokenCost: 1}}
+
+	// Assert - the client never sends again, so the handler must not wait for its next message
+	select {
+	case err := <-returned:
+		assert.ErrorIs(t, err, sendErr)
+	case <-time.After(time.Second):
+		assert.Fail(t, "The stream did not end after a failed send")
+	}
+}
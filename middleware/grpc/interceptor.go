@@ -0,0 +1,148 @@
+// Package grpcmiddleware provides gRPC server interceptors that rate limit calls with the rate limiter, either
+// through the remote service or embedded in the same process.
+package grpcmiddleware
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/carteralbrecht/rate-limiter/client"
+	"github.com/carteralbrecht/rate-limiter/middleware"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type options struct {
+	keyFunc    KeyFunc
+	costFunc   func(ctx context.Context, fullMethod string) int
+	keyPrefix  string
+	failClosed bool
+}
+
+// Option configures the interceptors.
+type Option func(*options)
+
+// WithKeyFunc sets how the rate limit key is derived from a call. Defaults to Join(ByPeer(), ByMethod()), limiting
+// each client per method.
+func WithKeyFunc(keyFunc KeyFunc) Option {
+	return func(o *options) { o.keyFunc = keyFunc }
+}
+
+// WithKeyPrefix prepends prefix to every key, so the limiter's policies can match the service's keys by prefix.
+// Defaults to "grpc:".
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) { o.keyPrefix = prefix }
+}
+
+// WithCost sets how many tokens a call consumes. Defaults to 1.
+func WithCost(costFunc func(ctx context.Context, fullMethod string) int) Option {
+	return func(o *options) { o.costFunc = costFunc }
+}
+
+// WithFailClosed rejects calls with codes.Unavailable when the limiter cannot be reached. By default such calls are
+// let through, so an outage of the limiter does not take the service down with it.
+func WithFailClosed() Option {
+	return func(o *options) { o.failClosed = true }
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		keyFunc:   Join(ByPeer(), ByMethod()),
+		costFunc:  func(context.Context, string) int { return 1 },
+		keyPrefix: "grpc:",
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// check returns the error to fail the call with, or nil if it may proceed, along with the trailer describing the
+// limit.
+func (o options) check(ctx context.Context, limiter middleware.Limiter, fullMethod string) (metadata.MD, error) {
+	key := o.keyFunc(ctx, fullMethod)
+	if key == "" {
+		return nil, nil
+	}
+	key = o.keyPrefix + key
+
+	decision, err := limiter.Allow(ctx, key, o.costFunc(ctx, fullMethod))
+	if err != nil {
+		log.Printf("Failed to check rate limit for %s: %v", key, err)
+		if o.failClosed {
+			return nil, status.Error(codes.Unavailable, "rate limiter unavailable")
+		}
+		return nil, nil
+	}
+
+	trailer := decisionTrailer(decision)
+	if decision.Allowed {
+		return trailer, nil
+	}
+	return trailer, deniedError(decision)
+}
+
+// decisionTrailer describes the limit in the same terms as the HTTP RateLimit headers.
+func decisionTrailer(decision client.Decision) metadata.MD {
+	md := metadata.Pairs(
+		"ratelimit-limit", strconv.Itoa(decision.Limit),
+		"ratelimit-remaining", strconv.Itoa(max(decision.Remaining, 0)),
+		"ratelimit-reset", strconv.FormatInt(middleware.Seconds(decision.ResetAfter), 10),
+	)
+	if !decision.Allowed && decision.RetryAfter >= 0 {
+		md.Set("retry-after", strconv.FormatInt(middleware.Seconds(decision.RetryAfter), 10))
+	}
+	return md
+}
+
+// deniedError is the ResourceExhausted error returned for a denied call, with a RetryInfo detail telling clients
+// when to retry unless the call can never be allowed.
+func deniedError(decision client.Decision) error {
+	if decision.RetryAfter < 0 {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded: request cost exceeds the limit")
+	}
+
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(decision.RetryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor rate limits unary calls. Denied calls fail with codes.ResourceExhausted and never reach
+// the handler. The limit is reported in the ratelimit-limit, ratelimit-remaining and ratelimit-reset trailers,
+// plus retry-after when the call was denied.
+func UnaryServerInterceptor(limiter middleware.Limiter, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		trailer, err := o.check(ctx, limiter, info.FullMethod)
+		if trailer != nil {
+			_ = grpc.SetTrailer(ctx, trailer)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rate limits streams when they are opened, consuming tokens once per stream rather than
+// per message. Denied streams fail like denied unary calls.
+func StreamServerInterceptor(limiter middleware.Limiter, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		trailer, err := o.check(ss.Context(), limiter, info.FullMethod)
+		if trailer != nil {
+			ss.SetTrailer(trailer)
+		}
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
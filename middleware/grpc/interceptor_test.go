@@ -0,0 +1,189 @@
+package grpcmiddleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carteralbrecht/rate-limiter/client"
+	"github.com/carteralbrecht/rate-limiter/middleware"
+	"github.com/carteralbrecht/rate-limiter/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeLimiter answers every check with the same decision and records the keys it was asked about.
+type fakeLimiter struct {
+	decision client.Decision
+	err      error
+	keys     []string
+}
+
+func (l *fakeLimiter) Allow(ctx context.Context, key string, cost int) (client.Decision, error) {
+	l.keys = append(l.keys, key)
+	return l.decision, l.err
+}
+
+// newHealthClient serves the gRPC health service behind the interceptors.
+func newHealthClient(t *testing.T, limiter middleware.Limiter, opts ...Option) healthpb.HealthClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(limiter, opts...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(limiter, opts...)),
+	)
+	healthpb.RegisterHealthServer(gs, health.NewServer())
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryServerInterceptor_Allowed(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Allowed: true, Remaining: 4, Limit: 5, ResetAfter: 1500 * time.Millisecond}}
+	healthClient := newHealthClient(t, limiter)
+	var trailer metadata.MD
+
+	// Act
+	_, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grpc:bufconn:/grpc.health.v1.Health/Check"}, limiter.keys)
+	assert.Equal(t, []string{"5"}, trailer.Get("ratelimit-limit"))
+	assert.Equal(t, []string{"4"}, trailer.Get("ratelimit-remaining"))
+	assert.Equal(t, []string{"2"}, trailer.Get("ratelimit-reset"), "Reset should be rounded up to whole seconds")
+	assert.Empty(t, trailer.Get("retry-after"))
+}
+
+func TestUnaryServerInterceptor_Denied(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Limit: 5, RetryAfter: 200 * time.Millisecond, ResetAfter: 5 * time.Second}}
+	healthClient := newHealthClient(t, limiter)
+	var trailer metadata.MD
+
+	// Act
+	_, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+
+	// Assert
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	assert.Equal(t, 200*time.Millisecond, st.Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+	assert.Equal(t, []string{"1"}, trailer.Get("retry-after"))
+	assert.Equal(t, []string{"0"}, trailer.Get("ratelimit-remaining"))
+}
+
+func TestUnaryServerInterceptor_NeverAllowed(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Limit: 5, RetryAfter: -1}}
+	healthClient := newHealthClient(t, limiter)
+	var trailer metadata.MD
+
+	// Act
+	_, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+
+	// Assert
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Empty(t, st.Details(), "A call that can never be allowed should not suggest a retry")
+	assert.Empty(t, trailer.Get("retry-after"))
+}
+
+func TestUnaryServerInterceptor_LimiterError(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{err: errors.New("connection refused")}
+	failOpen := newHealthClient(t, limiter)
+	failClosed := newHealthClient(t, limiter, WithFailClosed())
+	ctx := context.Background()
+
+	// Act
+	_, openErr := failOpen.Check(ctx, &healthpb.HealthCheckRequest{})
+	_, closedErr := failClosed.Check(ctx, &healthpb.HealthCheckRequest{})
+
+	// Assert
+	assert.NoError(t, openErr, "Calls should be let through by default when the limiter fails")
+	assert.Equal(t, codes.Unavailable, status.Code(closedErr))
+}
+
+func TestUnaryServerInterceptor_EmptyKeySkipsLimit(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{}}
+	healthClient := newHealthClient(t, limiter, WithKeyFunc(func(context.Context, string) string { return "" }))
+
+	// Act
+	_, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Empty(t, limiter.keys)
+}
+
+func TestUnaryServerInterceptor_MissingMetadataIsLimited(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Limit: 1}}
+	healthClient := newHealthClient(t, limiter, WithKeyFunc(ByMetadata("x-tenant-id", nil)))
+
+	// Act
+	_, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{})
+
+	// Assert
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "Leaving the metadata out should not bypass the limit")
+	assert.Equal(t, []string{"grpc:" + Anonymous}, limiter.keys)
+}
+
+func TestStreamServerInterceptor_Denied(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Limit: 1, RetryAfter: 3 * time.Second}}
+	healthClient := newHealthClient(t, limiter, WithKeyPrefix("svc:"), WithKeyFunc(ByMethod()))
+
+	// Act
+	stream, err := healthClient.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+
+	// Assert
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"svc:/grpc.health.v1.Health/Watch"}, limiter.keys)
+	assert.Equal(t, []string{"3"}, stream.Trailer().Get("retry-after"))
+}
+
+func TestUnaryServerInterceptor_Embedded(t *testing.T) {
+	// Arrange
+	backend := server.NewMemoryBackend(server.MemoryOptions{})
+	t.Cleanup(func() { backend.Close() })
+	policies, err := server.NewPolicySet(server.DefaultPolicy(), []server.Policy{
+		{Name: "health", Prefix: "grpc:", Capacity: 2, RefillRate: 0.001},
+	})
+	require.NoError(t, err)
+	healthClient := newHealthClient(t, middleware.Embedded(server.NewRateLimiterWithBackend(backend, policies)))
+	ctx := context.Background()
+
+	// Act
+	var codesSeen []codes.Code
+	for range 3 {
+		_, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		codesSeen = append(codesSeen, status.Code(err))
+	}
+
+	// Assert
+	assert.Equal(t, []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted}, codesSeen)
+}
@@ -0,0 +1,71 @@
+package grpcmiddleware
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// KeyFunc derives the rate limit key for a call to fullMethod, e.g. "/ratelimiter.RateLimiter/CheckLimit".
+// Returning an empty key lets the call through without checking a limit.
+type KeyFunc func(ctx context.Context, fullMethod string) string
+
+// ByMethod limits each method separately, keyed by its full name.
+func ByMethod() KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		return fullMethod
+	}
+}
+
+// ByPeer limits each client separately, keyed by the IP address of the connection's peer. Calls without a peer
+// address are not limited.
+func ByPeer() KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return ""
+		}
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+		return addr
+	}
+}
+
+// Anonymous is the key of calls that lack the metadata a KeyFunc looks for, if it has no other fallback. All such
+// calls share its limit.
+const Anonymous = "anonymous"
+
+// ByMetadata limits calls by the first value of the incoming metadata key name, e.g. a tenant ID or API key.
+// Calls without it are keyed by fallback instead, e.g. ByPeer(), so clients cannot bypass the limit by leaving the
+// metadata out. A nil fallback keys them all as Anonymous.
+func ByMetadata(name string, fallback KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		if values := metadata.ValueFromIncomingContext(ctx, name); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+		if fallback == nil {
+			return Anonymous
+		}
+		return fallback(ctx, fullMethod)
+	}
+}
+
+// Join combines keys with colons, e.g. Join(ByMetadata("x-tenant-id", nil), ByMethod()) limits each tenant per
+// method.
+// The call is not limited if any of the keys is empty.
+func Join(keyFuncs ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		parts := make([]string, len(keyFuncs))
+		for i, keyFunc := range keyFuncs {
+			if parts[i] = keyFunc(ctx, fullMethod); parts[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(parts, ":")
+	}
+}
@@ -0,0 +1,40 @@
+package grpcmiddleware
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestKeyFuncs(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-tenant-id", "acme"))
+	method := "/pkg.Service/Method"
+
+	tests := []struct {
+		name    string
+		keyFunc KeyFunc
+		ctx     context.Context
+		want    string
+	}{
+		{"method", ByMethod(), ctx, method},
+		{"peer IP without port", ByPeer(), ctx, "10.0.0.1"},
+		{"no peer", ByPeer(), context.Background(), ""},
+		{"metadata", ByMetadata("x-tenant-id", nil), ctx, "acme"},
+		{"missing metadata", ByMetadata("x-api-key", nil), ctx, Anonymous},
+		{"missing metadata with fallback", ByMetadata("x-api-key", ByPeer()), ctx, "10.0.0.1"},
+		{"metadata with fallback", ByMetadata("x-tenant-id", ByPeer()), ctx, "acme"},
+		{"joined", Join(ByMetadata("x-tenant-id", nil), ByMethod()), ctx, "acme:" + method},
+		{"joined with a missing part", Join(ByPeer(), ByMethod()), context.Background(), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.keyFunc(tt.ctx, method))
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package httpmiddleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// KeyFunc derives the rate limit key for a request. Returning an empty key lets the request through without
+// checking a limit.
+type KeyFunc func(r *http.Request) string
+
+// ClientIP limits each client separately, keyed by its IP address. Requests arriving from trustedProxies, such as
+// a load balancer's subnet, are attributed to the address the proxy reports in X-Forwarded-For: the rightmost
+// address that is not itself a trusted proxy. X-Forwarded-For is ignored for requests from any other address, since
+// clients can set it to anything.
+func ClientIP(trustedProxies ...netip.Prefix) KeyFunc {
+	trusted := func(ip netip.Addr) bool {
+		for _, p := range trustedProxies {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		ip, ok := parseIP(r.RemoteAddr)
+		if !ok {
+			return ""
+		}
+
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(header, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0 && trusted(ip); i-- {
+			hop, ok := parseIP(strings.TrimSpace(hops[i]))
+			if !ok {
+				break
+			}
+			ip = hop
+		}
+		return ip.String()
+	}
+}
+
+// parseIP parses an IP address with or without a port, mapping IPv4-mapped IPv6 addresses to IPv4.
+func parseIP(s string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// Anonymous is the key of requests that lack the header or token a KeyFunc looks for, if it has no other fallback.
+// All such requests share its limit.
+const Anonymous = "anonymous"
+
+// fallbackKey returns the key fallback derives for r, or Anonymous if fallback is nil.
+func fallbackKey(fallback KeyFunc, r *http.Request) string {
+	if fallback == nil {
+		return Anonymous
+	}
+	return fallback(r)
+}
+
+// Header limits requests by the value of a request header, e.g. "X-API-Key". Requests without it are keyed by
+// fallback instead, e.g. ClientIP(), so clients cannot bypass the limit by leaving the header out. A nil fallback
+// keys them all as Anonymous.
+func Header(name string, fallback KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+		return fallbackKey(fallback, r)
+	}
+}
+
+// JWTSubject limits requests by the subject ("sub" claim) of the bearer token in the Authorization header.
+// Requests without a bearer token or subject are keyed by fallback instead, e.g. ClientIP(), so clients cannot
+// bypass the limit by leaving the token out. A nil fallback keys them all as Anonymous.
+//
+// The token's signature is not verified, so the middleware must run after the handler that authenticates
+// requests; otherwise a client could spend another subject's tokens by forging a token with its subject.
+func JWTSubject(fallback KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		if subject := jwtSubject(r); subject != "" {
+			return subject
+		}
+		return fallbackKey(fallback, r)
+	}
+}
+
+// jwtSubject returns the subject of the request's bearer token without verifying it, or "" if there is none.
+func jwtSubject(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
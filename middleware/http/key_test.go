@@ -0,0 +1,88 @@
+package httpmiddleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   []string
+		trustedProxies []netip.Prefix
+		want           string
+	}{
+		{"remote address", "192.0.2.1:5000", nil, trusted, "192.0.2.1"},
+		{"untrusted peer cannot spoof", "192.0.2.1:5000", []string{"198.51.100.7"}, trusted, "192.0.2.1"},
+		{"no trusted proxies", "10.0.0.2:5000", []string{"198.51.100.7"}, nil, "10.0.0.2"},
+		{"trusted proxy", "10.0.0.2:5000", []string{"198.51.100.7"}, trusted, "198.51.100.7"},
+		{"rightmost untrusted hop", "10.0.0.2:5000", []string{"203.0.113.9, 198.51.100.7, 10.0.0.3"}, trusted, "198.51.100.7"},
+		{"multiple headers", "10.0.0.2:5000", []string{"203.0.113.9", "198.51.100.7"}, trusted, "198.51.100.7"},
+		{"malformed hop", "10.0.0.2:5000", []string{"bogus, 10.0.0.3"}, trusted, "10.0.0.3"},
+		{"IPv6", "[2001:db8::1]:5000", nil, trusted, "2001:db8::1"},
+		{"IPv4-mapped IPv6", "[::ffff:192.0.2.1]:5000", nil, trusted, "192.0.2.1"},
+		{"unparseable remote address", "pipe", nil, trusted, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tt.want, ClientIP(tt.trustedProxies...)(req))
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:5000"
+	req.Header.Set("X-API-Key", "key-1")
+
+	assert.Equal(t, "key-1", Header("X-API-Key", nil)(req))
+	assert.Equal(t, Anonymous, Header("X-Tenant-ID", nil)(req), "Requests without the header should share one limit")
+	assert.Equal(t, "192.0.2.1", Header("X-Tenant-ID", ClientIP())(req), "Requests without the header should use the fallback")
+}
+
+func TestJWTSubject(t *testing.T) {
+	token := func(payload string) string {
+		return "Bearer eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		fallback      KeyFunc
+		want          string
+	}{
+		{"subject", token(`{"sub":"user-1","exp":1}`), nil, "user-1"},
+		{"no subject", token(`{"exp":1}`), nil, Anonymous},
+		{"not a bearer token", "Basic dXNlcjpwYXNz", nil, Anonymous},
+		{"malformed token", "Bearer abc", nil, Anonymous},
+		{"malformed payload", token(`not json`), nil, Anonymous},
+		{"no token", "", nil, Anonymous},
+		{"no token with fallback", "", ClientIP(), "192.0.2.1"},
+		{"subject with fallback", token(`{"sub":"user-1"}`), ClientIP(), "user-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:5000"
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			assert.Equal(t, tt.want, JWTSubject(tt.fallback)(req))
+		})
+	}
+}
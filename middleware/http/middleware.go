@@ -0,0 +1,116 @@
+// Package httpmiddleware provides net/http middleware that rate limits requests with the rate limiter, either
+// through the remote service or embedded in the same process.
+package httpmiddleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/carteralbrecht/rate-limiter/client"
+	"github.com/carteralbrecht/rate-limiter/middleware"
+)
+
+type options struct {
+	keyFunc    KeyFunc
+	costFunc   func(r *http.Request) int
+	keyPrefix  string
+	failClosed bool
+}
+
+// Option configures the middleware.
+type Option func(*options)
+
+// WithKeyFunc sets how the rate limit key is derived from a request. Defaults to ClientIP without trusted proxies.
+func WithKeyFunc(keyFunc KeyFunc) Option {
+	return func(o *options) { o.keyFunc = keyFunc }
+}
+
+// WithKeyPrefix prepends prefix to every key, so the limiter's policies can match the service's keys by prefix.
+// Defaults to "http:".
+func WithKeyPrefix(prefix string) Option {
+	return func(o *options) { o.keyPrefix = prefix }
+}
+
+// WithCost sets how many tokens a request consumes. Defaults to 1.
+func WithCost(costFunc func(r *http.Request) int) Option {
+	return func(o *options) { o.costFunc = costFunc }
+}
+
+// WithFailClosed rejects requests with 503 Service Unavailable when the limiter cannot be reached. By default such
+// requests are let through, so an outage of the limiter does not take the service down with it.
+func WithFailClosed() Option {
+	return func(o *options) { o.failClosed = true }
+}
+
+// New returns middleware that checks every request against limiter before passing it on. The limit is reported in
+// the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers. Denied requests get 429 Too Many Requests
+// with a Retry-After header and a JSON body of the form {"error": message, "retry_after": seconds}, and never
+// reach the next handler.
+func New(limiter middleware.Limiter, opts ...Option) func(http.Handler) http.Handler {
+	o := options{
+		keyFunc:   ClientIP(),
+		costFunc:  func(*http.Request) int { return 1 },
+		keyPrefix: "http:",
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := o.keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key = o.keyPrefix + key
+
+			decision, err := limiter.Allow(r.Context(), key, o.costFunc(r))
+			if err != nil {
+				log.Printf("Failed to check rate limit for %s: %v", key, err)
+				if o.failClosed {
+					writeJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "rate limiter unavailable"})
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			setHeaders(w.Header(), decision)
+			if !decision.Allowed {
+				writeDenied(w, decision)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setHeaders sets the RateLimit headers describing the limit, and Retry-After if the request was denied.
+func setHeaders(h http.Header, decision client.Decision) {
+	h.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(max(decision.Remaining, 0)))
+	h.Set("RateLimit-Reset", strconv.FormatInt(middleware.Seconds(decision.ResetAfter), 10))
+	if !decision.Allowed && decision.RetryAfter >= 0 {
+		h.Set("Retry-After", strconv.FormatInt(middleware.Seconds(decision.RetryAfter), 10))
+	}
+}
+
+// writeDenied writes the 429 response for a denied request. retry_after is omitted if the request can never be
+// allowed.
+func writeDenied(w http.ResponseWriter, decision client.Decision) {
+	if decision.RetryAfter < 0 {
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{"error": "rate limit exceeded: request cost exceeds the limit"})
+		return
+	}
+
+	writeJSON(w, http.StatusTooManyRequests, map[string]any{"error": "rate limit exceeded", "retry_after": middleware.Seconds(decision.RetryAfter)})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,136 @@
+package httpmiddleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carteralbrecht/rate-limiter/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLimiter answers every check with the same decision and records the keys it was asked about.
+type fakeLimiter struct {
+	decision client.Decision
+	err      error
+	keys     []string
+}
+
+func (l *fakeLimiter) Allow(ctx context.Context, key string, cost int) (client.Decision, error) {
+	l.keys = append(l.keys, key)
+	return l.decision, l.err
+}
+
+// serve sends a request from 192.0.2.1 through the middleware and reports whether it reached the handler.
+func serve(limiter *fakeLimiter, opts ...Option) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	handler := New(limiter, opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:5000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, reached
+}
+
+func TestMiddleware_Allowed(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Allowed: true, Remaining: 9, Limit: 10, ResetAfter: 900 * time.Millisecond}}
+
+	// Act
+	rec, reached := serve(limiter)
+
+	// Assert
+	assert.True(t, reached)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"http:192.0.2.1"}, limiter.keys)
+	assert.Equal(t, "10", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "9", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Reset"), "Reset should be rounded up to whole seconds")
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestMiddleware_Denied(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Limit: 10, RetryAfter: 2500 * time.Millisecond, ResetAfter: 10 * time.Second}}
+
+	// Act
+	rec, reached := serve(limiter)
+
+	// Assert
+	assert.False(t, reached)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "3", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error": "rate limit exceeded", "retry_after": 3}`, rec.Body.String())
+}
+
+func TestMiddleware_NeverAllowed(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Limit: 10, RetryAfter: -1}}
+
+	// Act
+	rec, _ := serve(limiter)
+
+	// Assert
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Empty(t, rec.Header().Get("Retry-After"), "A request that can never be allowed should not suggest a retry")
+	assert.JSONEq(t, `{"error": "rate limit exceeded: request cost exceeds the limit"}`, rec.Body.String())
+}
+
+func TestMiddleware_LimiterError(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{err: errors.New("connection refused")}
+
+	// Act
+	_, openReached := serve(limiter)
+	closedRec, closedReached := serve(limiter, WithFailClosed())
+
+	// Assert
+	assert.True(t, openReached, "Requests should be let through by default when the limiter fails")
+	assert.False(t, closedReached)
+	assert.Equal(t, http.StatusServiceUnavailable, closedRec.Code)
+}
+
+func TestMiddleware_EmptyKeySkipsLimit(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{}
+
+	// Act
+	rec, reached := serve(limiter, WithKeyFunc(func(*http.Request) string { return "" }))
+
+	// Assert
+	assert.True(t, reached)
+	assert.Empty(t, limiter.keys)
+	assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+}
+
+func TestMiddleware_MissingHeaderIsLimited(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Limit: 10}}
+
+	// Act
+	rec, reached := serve(limiter, WithKeyFunc(Header("X-API-Key", nil)))
+
+	// Assert
+	assert.False(t, reached, "Leaving the header out should not bypass the limit")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, []string{"http:" + Anonymous}, limiter.keys)
+}
+
+func TestMiddleware_KeyPrefix(t *testing.T) {
+	// Arrange
+	limiter := &fakeLimiter{decision: client.Decision{Allowed: true}}
+
+	// Act
+	serve(limiter, WithKeyPrefix("api:"))
+
+	// Assert
+	assert.Equal(t, []string{"api:192.0.2.1"}, limiter.keys)
+}
@@ -0,0 +1,47 @@
+// Package middleware holds what the gRPC and HTTP middleware packages share: the Limiter they check requests
+// against, and adapters for a remote rate limiter service or one embedded in the same process.
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/carteralbrecht/rate-limiter/client"
+	"github.com/carteralbrecht/rate-limiter/server"
+)
+
+// Limiter decides whether a request may proceed. *client.Client is a Limiter that checks limits with the remote
+// service's CheckLimit RPC; Embedded adapts a RateLimiter running in the same process.
+type Limiter interface {
+	Allow(ctx context.Context, key string, cost int) (client.Decision, error)
+}
+
+// Embedded returns a Limiter that checks limits against rateLimiter directly, without an RPC. The rate limiter
+// keeps its state in its own backend, so instances sharing a Redis backend share limits with the remote service.
+func Embedded(rateLimiter *server.RateLimiter) Limiter {
+	return embedded{rateLimiter}
+}
+
+type embedded struct {
+	rateLimiter *server.RateLimiter
+}
+
+func (e embedded) Allow(ctx context.Context, key string, cost int) (client.Decision, error) {
+	result, err := e.rateLimiter.CheckAndConsumeTokens(ctx, key, cost)
+	if err != nil {
+		return client.Decision{}, err
+	}
+	return client.Decision{
+		Allowed:    result.Allowed,
+		Remaining:  result.Remaining,
+		Limit:      result.Limit,
+		RetryAfter: result.RetryAfter,
+		ResetAfter: result.ResetAfter,
+	}, nil
+}
+
+// Seconds converts d to the whole number of seconds used by the Retry-After and RateLimit-Reset headers, rounded
+// up so clients never retry too early.
+func Seconds(d time.Duration) int64 {
+	return int64((max(d, 0) + time.Second - 1) / time.Second)
+}
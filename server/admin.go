@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Bucket is the state of a key's limit under the policy matching it, as reported by the admin operations.
+type Bucket struct {
+	Key string
+
+	// Policy is the policy matching Key.
+	Policy Policy
+
+	// Remaining is the number of tokens that can be consumed right now.
+	Remaining int
+
+	// Limit is the maximum number of tokens available for the key, e.g. the bucket capacity.
+	Limit int
+
+	// ResetAfter is the time until the limit is fully replenished, or negative if it never will be.
+	ResetAfter time.Duration
+
+	// LeasesInUse is the number of leases held for the key.
+	LeasesInUse int
+}
+
+// GetBucket returns the state of the key's limit under its current policy, without consuming anything. Returns a
+// *BackendError if the backend fails. Unlike checks, admin operations never fall back to the policy's failure
+// mode.
+func (r *RateLimiter) GetBucket(ctx context.Context, key string) (Bucket, error) {
+	return r.bucket(ctx, "GetBucket", key, r.Policies().Match(key))
+}
+
+// bucket reads the state of the key's limit under policy. A check that consumes nothing reports the state of
+// every algorithm without changing it.
+func (r *RateLimiter) bucket(ctx context.Context, op string, key string, policy Policy) (Bucket, error) {
+	result, err := r.backend.Check(ctx, Check{Key: key, Policy: policy})
+	if err != nil {
+		log.Printf("%s: Failed to read bucket for key %s: %v", op, key, err)
+		return Bucket{}, &BackendError{Op: op, Key: key, Err: err}
+	}
+
+	inUse, err := r.backend.LeaseCount(ctx, key)
+	if err != nil {
+		log.Printf("%s: Failed to read leases for key %s: %v", op, key, err)
+		return Bucket{}, &BackendError{Op: op, Key: key, Err: err}
+	}
+
+	return Bucket{
+		Key:         key,
+		Policy:      policy,
+		Remaining:   result.Remaining,
+		Limit:       result.Limit,
+		ResetAfter:  result.ResetAfter,
+		LeasesInUse: inUse,
+	}, nil
+}
+
+// ResetBucket returns the key's limit to full, as if the key had not been used, and returns its new state. Leases
+// held for the key are kept. Returns a *BackendError if the backend fails.
+func (r *RateLimiter) ResetBucket(ctx context.Context, key string) (Bucket, error) {
+	policy := r.Policies().Match(key)
+	return r.setTokens(ctx, "ResetBucket", key, policy, policy.limit())
+}
+
+// SetTokens sets the number of tokens the key can consume right now under its current policy, as if its limit had
+// been consumed down to tokens, and returns its new state. tokens is clamped between 0 and the policy's limit.
+// Returns a *BackendError if the backend fails.
+func (r *RateLimiter) SetTokens(ctx context.Context, key string, tokens int) (Bucket, error) {
+	policy := r.Policies().Match(key)
+	return r.setTokens(ctx, "SetTokens", key, policy, min(max(tokens, 0), policy.limit()))
+}
+
+func (r *RateLimiter) setTokens(ctx context.Context, op string, key string, policy Policy, tokens int) (Bucket, error) {
+	if err := r.backend.SetTokens(ctx, key, policy, tokens); err != nil {
+		log.Printf("%s: Failed to set tokens for key %s: %v", op, key, err)
+		return Bucket{}, &BackendError{Op: op, Key: key, Err: err}
+	}
+
+	log.Printf("%s: Set key %s to %d of %d tokens", op, key, tokens, policy.limit())
+	return r.bucket(ctx, op, key, policy)
+}
+
+// DeleteBucket removes everything stored for the key: its leases and the state of every algorithm, including
+// state left over from algorithms its policy no longer uses. Fixed window counters are only removed for the
+// current window, and only if the key's current policy uses FixedWindow. Returns whether anything was stored, or
+// a *BackendError if the backend fails.
+func (r *RateLimiter) DeleteBucket(ctx context.Context, key string) (bool, error) {
+	deleted, err := r.backend.Delete(ctx, key, r.Policies().Match(key))
+	if err != nil {
+		log.Printf("DeleteBucket: Failed to delete key %s: %v", key, err)
+		return false, &BackendError{Op: "DeleteBucket", Key: key, Err: err}
+	}
+
+	log.Printf("DeleteBucket: Deleted key %s: %t", key, deleted)
+	return deleted, nil
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin_SetTokensAndReset(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"token bucket", Policy{Name: "p", Prefix: "k", Capacity: 10, RefillRate: 1}},
+		{"gcra", Policy{Name: "p", Prefix: "k", Algorithm: GCRA, Capacity: 10, RefillRate: 1}},
+		{"sliding window log", Policy{Name: "p", Prefix: "k", Algorithm: SlidingWindowLog, Limit: 10, Window: time.Minute}},
+		{"sliding window counter", Policy{Name: "p", Prefix: "k", Algorithm: SlidingWindowCounter, Limit: 10, Window: time.Minute}},
+		{"fixed window", Policy{Name: "p", Prefix: "k", Algorithm: FixedWindow, Limit: 10, Window: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rateLimiter, _, _ := newMemoryLimiter(t, MemoryOptions{}, tt.policy)
+			ctx := context.Background()
+
+			// Act
+			set, err := rateLimiter.SetTokens(ctx, "key", 3)
+			require.NoError(t, err)
+			fits, err := rateLimiter.CheckAndConsumeTokens(ctx, "key", 3)
+			require.NoError(t, err)
+			exceeds, err := rateLimiter.CheckAndConsumeTokens(ctx, "key", 1)
+			require.NoError(t, err)
+			reset, err := rateLimiter.ResetBucket(ctx, "key")
+			require.NoError(t, err)
+
+			// Assert
+			assert.Equal(t, 3, set.Remaining)
+			assert.Equal(t, 10, set.Limit)
+			assert.Equal(t, "p", set.Policy.Name)
+			assert.True(t, fits.Allowed, "The tokens set should be consumable")
+			assert.False(t, exceeds.Allowed, "No more than the tokens set should be consumable")
+			assert.Equal(t, 10, reset.Remaining, "A reset should return the limit to full")
+		})
+	}
+}
+
+func TestAdmin_SetTokensClampsToLimit(t *testing.T) {
+	// Arrange
+	rateLimiter, _, _ := newMemoryLimiter(t, MemoryOptions{})
+	ctx := context.Background()
+
+	// Act
+	above, err := rateLimiter.SetTokens(ctx, "key", 50)
+	require.NoError(t, err)
+	below, err := rateLimiter.SetTokens(ctx, "key", -5)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, defaultBucketSize, above.Remaining)
+	assert.Equal(t, 0, below.Remaining)
+	assert.Equal(t, 10*time.Second, below.ResetAfter)
+}
+
+func TestAdmin_GetBucketConsumesNothing(t *testing.T) {
+	// Arrange
+	policy := Policy{Name: "tenants", Prefix: "tenant:", Capacity: 100, RefillRate: 10, MaxConcurrent: 5}
+	rateLimiter, _, _ := newMemoryLimiter(t, MemoryOptions{}, policy)
+	ctx := context.Background()
+
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, "tenant:acme", 40)
+	require.NoError(t, err)
+	_, err = rateLimiter.AcquireLease(ctx, "tenant:acme")
+	require.NoError(t, err)
+
+	// Act
+	first, err := rateLimiter.GetBucket(ctx, "tenant:acme")
+	require.NoError(t, err)
+	second, err := rateLimiter.GetBucket(ctx, "tenant:acme")
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, Bucket{
+		Key:         "tenant:acme",
+		Policy:      first.Policy,
+		Remaining:   60,
+		Limit:       100,
+		ResetAfter:  4 * time.Second,
+		LeasesInUse: 1,
+	}, first)
+	assert.Equal(t, "tenants", first.Policy.Name)
+	assert.Equal(t, first, second)
+}
+
+func TestAdmin_DeleteBucket(t *testing.T) {
+	// Arrange
+	policy := Policy{Name: "tenants", Prefix: "tenant:", Capacity: 100, RefillRate: 10, MaxConcurrent: 5}
+	rateLimiter, backend, _ := newMemoryLimiter(t, MemoryOptions{}, policy)
+	ctx := context.Background()
+
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, "tenant:acme", 40)
+	require.NoError(t, err)
+	_, err = rateLimiter.AcquireLease(ctx, "tenant:acme")
+	require.NoError(t, err)
+
+	// Act
+	deleted, err := rateLimiter.DeleteBucket(ctx, "tenant:acme")
+	require.NoError(t, err)
+	again, err := rateLimiter.DeleteBucket(ctx, "tenant:acme")
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, deleted)
+	assert.False(t, again, "Nothing should be left to delete")
+	count, err := backend.BucketCount(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestAdmin_SetTokensRedis(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+
+	mock.ExpectEvalSha(setTokensScript.Hash(), []string{"rl:bucket:{user:1}"}, defaultBucketSize, defaultRefillRate, 4).SetVal(int64(1))
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{user:1}"}, defaultBucketSize, defaultRefillRate, 0).
+		SetVal([]interface{}{int64(1), int64(4), int64(0), int64(6_000)})
+	mock.ExpectEvalSha(leaseCountScript.Hash(), []string{"rl:leases:{user:1}"}).SetVal(int64(0))
+
+	// Act
+	bucket, err := rateLimiter.SetTokens(ctx, "user:1", 4)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 4, bucket.Remaining)
+	assert.Equal(t, 6*time.Second, bucket.ResetAfter)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdmin_DeleteBucketRedis(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+
+	// Mock every algorithm's key and the lease set being deleted at once
+	mock.ExpectDel("rl:bucket:{user:1}", "rl:tat:{user:1}", "rl:log:{user:1}", "rl:counter:{user:1}",
+		"rl:leases:{user:1}").SetVal(2)
+
+	// Act
+	deleted, err := rateLimiter.DeleteBucket(ctx, "user:1")
+
+	// Assert
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdmin_BackendError(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{user:1}"}, defaultBucketSize, defaultRefillRate, 0).
+		SetErr(errors.New("redis connection error"))
+
+	// Act
+	_, err := rateLimiter.GetBucket(ctx, "user:1")
+
+	// Assert
+	var backendErr *BackendError
+	require.ErrorAs(t, err, &backendErr)
+	assert.Equal(t, "GetBucket", backendErr.Op)
+	assert.Equal(t, "user:1", backendErr.Key)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
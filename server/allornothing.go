@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// allOrNothingLua refills every bucket and consumes from all of them only if every bucket has enough tokens.
+// Nothing is written when any bucket falls short. A key listed more than once must cover the sum of its costs.
+// It returns {allowed} followed by {remaining, retry_after_ms, reset_after_ms} for each key, with retry_after_ms
+// set to -1 when the key's cost can never be covered.
+//
+// KEYS[i]         - bucket key
+// ARGV[3*i - 2]   - bucket capacity
+// ARGV[3*i - 1]   - refill rate in tokens per second
+// ARGV[3*i]       - token cost
+const allOrNothingLua = tokenBucketFunctionsLua + `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local capacity, rate, cost, available, tokens, first = {}, {}, {}, {}, {}, {}
+local allowed = 1
+for i = 1, #KEYS do
+  local key = KEYS[i]
+  capacity[i] = tonumber(ARGV[3 * i - 2])
+  rate[i] = tonumber(ARGV[3 * i - 1])
+  cost[i] = math.max(0, tonumber(ARGV[3 * i]))
+
+  if tokens[key] == nil then
+    tokens[key] = load_bucket(key, capacity[i], rate[i], now)
+    available[key] = tokens[key]
+    first[key] = i
+  end
+
+  if tokens[key] >= cost[i] then
+    tokens[key] = tokens[key] - cost[i]
+  else
+    allowed = 0
+  end
+end
+
+if allowed == 1 then
+  for key, t in pairs(tokens) do
+    store_bucket(key, t, capacity[first[key]], rate[first[key]], now)
+  end
+else
+  tokens = available
+end
+
+local reply = {allowed}
+local needed = {}
+for i = 1, #KEYS do
+  local key = KEYS[i]
+  local t = tokens[key]
+
+  local retry_after = 0
+  if allowed == 0 then
+    needed[key] = (needed[key] or 0) + cost[i]
+    if needed[key] > capacity[i] or (needed[key] > t and rate[i] <= 0) then
+      retry_after = -1
+    elseif needed[key] > t then
+      retry_after = math.ceil((needed[key] - t) * 1000 / rate[i])
+    end
+  end
+
+  local reset_after = 0
+  if t < capacity[i] then
+    if rate[i] > 0 then
+      reset_after = math.ceil((capacity[i] - t) * 1000 / rate[i])
+    else
+      reset_after = -1
+    end
+  end
+
+  table.insert(reply, math.floor(t))
+  table.insert(reply, retry_after)
+  table.insert(reply, reset_after)
+end
+return reply
+`
+
+var allOrNothingScript = redis.NewScript(allOrNothingLua)
+
+// CheckAndConsumeTokensAllOrNothing consumes tokens from every descriptor's bucket only if all of them have enough
+// tokens, so a denial leaves every bucket untouched. Every result reports the same Allowed decision, and when
+// denied, RetryAfter is only set for the descriptors that fell short.
+//
+// With the Redis backend all buckets are updated by a single Lua script, so when Redis is sharded every key must
+// live in the same slot, e.g. by sharing a {hash tag}. Only token bucket policies support all-or-nothing checks;
+// an *AlgorithmError is returned if any descriptor matches a policy using another algorithm. If the backend fails,
+// the strictest failure mode among the descriptors' policies applies to all of them.
+func (r *RateLimiter) CheckAndConsumeTokensAllOrNothing(ctx context.Context, descriptors []Descriptor) ([]Result, error) {
+	policies := r.Policies()
+	checks := make([]Check, len(descriptors))
+	for i, d := range descriptors {
+		policy := policies.Match(d.Key)
+		if policy.Algorithm != TokenBucket {
+			return nil, &AlgorithmError{Op: "CheckAndConsumeTokensAllOrNothing", Key: d.Key, Policy: policy.Name, Algorithm: policy.Algorithm, Required: TokenBucket}
+		}
+		checks[i] = Check{Key: d.Key, Policy: policy, TokenCost: d.TokenCost}
+	}
+
+	log.Printf("CheckAndConsumeTokensAllOrNothing: Checking %d keys", len(descriptors))
+
+	if len(descriptors) == 0 {
+		return []Result{}, nil
+	}
+
+	results, err := r.backend.CheckAllOrNothing(ctx, checks)
+	if err != nil {
+		return r.failoverAll(ctx, "CheckAndConsumeTokensAllOrNothing", checks, err)
+	}
+	r.maybeReplay()
+
+	if !results[0].Allowed {
+		log.Printf("CheckAndConsumeTokensAllOrNothing: Not enough tokens for every key, no tokens consumed")
+	} else {
+		log.Printf("CheckAndConsumeTokensAllOrNothing: Consumed tokens from %d keys", len(descriptors))
+	}
+	return results, nil
+}
+
+// CheckAllOrNothing runs the all-or-nothing script against every check's token bucket.
+func (b *RedisBackend) CheckAllOrNothing(ctx context.Context, checks []Check) ([]Result, error) {
+	keys := make([]string, len(checks))
+	args := make([]interface{}, 0, 3*len(checks))
+	for i, c := range checks {
+		keys[i] = bucketKey(c.Key)
+		args = append(args, c.Policy.Capacity, c.Policy.RefillRate, c.TokenCost)
+	}
+
+	res, err := allOrNothingScript.Run(ctx, b.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := res[0] == 1
+	results := make([]Result, len(checks))
+	for i, c := range checks {
+		state := res[1+3*i:]
+		results[i] = Result{
+			Allowed:    allowed,
+			Remaining:  int(state[0]),
+			Limit:      c.Policy.Capacity,
+			RetryAfter: retryAfter(state[1], time.Millisecond),
+			ResetAfter: time.Duration(state[2]) * time.Millisecond,
+		}
+	}
+	return results, nil
+}
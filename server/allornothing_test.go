@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var allOrNothingPolicies = []Policy{
+	{Name: "tenants", Prefix: "tenant:", Capacity: 5, RefillRate: 1},
+	{Name: "ips", Prefix: "ip:", Algorithm: SlidingWindowLog, Limit: 5, Window: time.Minute},
+}
+
+func TestCheckAndConsumeTokensAllOrNothing(t *testing.T) {
+	type batch struct {
+		// advance is how far the clock moves before the check.
+		advance     time.Duration
+		descriptors []Descriptor
+		want        []Result
+	}
+
+	tests := []struct {
+		name    string
+		batches []batch
+	}{
+		{
+			name: "consumes from every bucket",
+			batches: []batch{
+				{descriptors: []Descriptor{{Key: "user:1", TokenCost: 1}, {Key: "tenant:acme", TokenCost: 2}}, want: []Result{
+					{Allowed: true, Remaining: 9, Limit: 10, ResetAfter: time.Second},
+					{Allowed: true, Remaining: 3, Limit: 5, ResetAfter: 2 * time.Second},
+				}},
+			},
+		},
+		{
+			name: "a denial debits no bucket",
+			batches: []batch{
+				{descriptors: []Descriptor{{Key: "user:1", TokenCost: 1}, {Key: "tenant:acme", TokenCost: 4}}, want: []Result{
+					{Allowed: true, Remaining: 9, Limit: 10, ResetAfter: time.Second},
+					{Allowed: true, Remaining: 1, Limit: 5, ResetAfter: 4 * time.Second},
+				}},
+				// Only the tenant bucket falls short, so only it reports a retry
+				{descriptors: []Descriptor{{Key: "user:1", TokenCost: 1}, {Key: "tenant:acme", TokenCost: 4}}, want: []Result{
+					{Allowed: false, Remaining: 9, Limit: 10, ResetAfter: time.Second},
+					{Allowed: false, Remaining: 1, Limit: 5, RetryAfter: 3 * time.Second, ResetAfter: 4 * time.Second},
+				}},
+				{advance: 3 * time.Second, descriptors: []Descriptor{{Key: "user:1", TokenCost: 1}, {Key: "tenant:acme", TokenCost: 4}}, want: []Result{
+					{Allowed: true, Remaining: 9, Limit: 10, ResetAfter: time.Second},
+					{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: 5 * time.Second},
+				}},
+			},
+		},
+		{
+			name: "a key listed twice must cover the sum of its costs",
+			batches: []batch{
+				{descriptors: []Descriptor{{Key: "tenant:acme", TokenCost: 3}, {Key: "tenant:acme", TokenCost: 3}}, want: []Result{
+					{Allowed: false, Remaining: 5, Limit: 5},
+					{Allowed: false, Remaining: 5, Limit: 5, RetryAfter: NeverAllowed},
+				}},
+				{descriptors: []Descriptor{{Key: "tenant:acme", TokenCost: 3}, {Key: "tenant:acme", TokenCost: 2}}, want: []Result{
+					{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: 5 * time.Second},
+					{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: 5 * time.Second},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rateLimiter, _, clock := newRedisLimiter(t, allOrNothingPolicies...)
+			for i, b := range tt.batches {
+				clock.Advance(b.advance)
+				results, err := rateLimiter.CheckAndConsumeTokensAllOrNothing(context.Background(), b.descriptors)
+				require.NoError(t, err, "batch %d", i)
+				assert.Equal(t, b.want, results, "batch %d", i)
+			}
+		})
+	}
+}
+
+func TestCheckAndConsumeTokensAllOrNothing_UnsupportedAlgorithm(t *testing.T) {
+	// Arrange
+	rateLimiter, mr, _ := newRedisLimiter(t, allOrNothingPolicies...)
+	ctx := context.Background()
+
+	// Act
+	_, err := rateLimiter.CheckAndConsumeTokensAllOrNothing(ctx, []Descriptor{
+		{Key: "user:1", TokenCost: 1},
+		{Key: "ip:10.0.0.1", TokenCost: 1},
+	})
+
+	// Assert
+	var algorithmErr *AlgorithmError
+	require.ErrorAs(t, err, &algorithmErr)
+	assert.Equal(t, "ip:10.0.0.1", algorithmErr.Key)
+	assert.Equal(t, SlidingWindowLog, algorithmErr.Algorithm)
+	assert.Empty(t, mr.Keys(), "Nothing should be consumed")
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// Check asks a Backend to consume TokenCost tokens from the limit Policy places on Key.
+type Check struct {
+	Key       string
+	Policy    Policy
+	TokenCost int
+}
+
+// LeaseState is a Backend's answer to a lease acquisition.
+type LeaseState struct {
+	// Acquired reports whether a slot was acquired.
+	Acquired bool
+
+	// InUse is the number of leases held for the key, including the new one if it was acquired.
+	InUse int
+
+	// ExpiresAt is when the new lease expires. Only set if the lease was acquired.
+	ExpiresAt time.Time
+}
+
+// Backend stores limiter state and applies the limiting algorithms to it. Every method must be atomic per key,
+// so that concurrent callers, possibly in other processes sharing the backend, never spend the same tokens.
+type Backend interface {
+	// Check consumes tokens if the check fits within its policy's limit.
+	Check(ctx context.Context, check Check) (Result, error)
+
+	// CheckBatch applies each check independently, as if Check had been called for each of them, and returns
+	// results and errors in the same order as checks. errs[i] is non-nil if checks[i] failed.
+	CheckBatch(ctx context.Context, checks []Check) (results []Result, errs []error)
+
+	// CheckAllOrNothing consumes tokens for every check only if all of them fit, leaving all state untouched
+	// otherwise. Every check uses a TokenBucket policy.
+	CheckAllOrNothing(ctx context.Context, checks []Check) ([]Result, error)
+
+	// Refill adds amount tokens to the token bucket for key, up to the policy's capacity, and returns the new
+	// token count.
+	Refill(ctx context.Context, key string, policy Policy, amount int) (int, error)
+
+	// AcquireLease records leaseID as holding one of the policy's MaxConcurrent slots for key, until it is
+	// released or its LeaseTTL passes.
+	AcquireLease(ctx context.Context, key string, policy Policy, leaseID string) (LeaseState, error)
+
+	// ReleaseLease frees the slot held by leaseID. Returns whether the lease was still held and the number of
+	// leases held for key afterwards.
+	ReleaseLease(ctx context.Context, key string, leaseID string) (bool, int, error)
+
+	// LeaseCount returns the number of unexpired leases held for key, without changing anything.
+	LeaseCount(ctx context.Context, key string) (int, error)
+
+	// SetTokens sets the state of key's limit under the policy's algorithm so that exactly tokens can be consumed
+	// right now, as if the limit had been consumed down to tokens. tokens is between 0 and the policy's limit;
+	// setting it to the limit returns the state to its initial value.
+	SetTokens(ctx context.Context, key string, policy Policy, tokens int) error
+
+	// Delete removes the state of every algorithm and the leases stored for key. Fixed window counters are only
+	// removed for the policy's current window, if the policy uses FixedWindow. Returns whether anything was stored.
+	Delete(ctx context.Context, key string, policy Policy) (bool, error)
+
+	// BucketCount returns the number of live entries holding limiter state. State that has expired, such as a
+	// full token bucket, is not counted. The count may be estimated where counting exactly would be too slow.
+	BucketCount(ctx context.Context) (int64, error)
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultFailureThreshold is the number of consecutive failed commands that opens the circuit.
+	defaultFailureThreshold = 5
+
+	// defaultOpenTimeout is how long the circuit stays open before a probe command is let through.
+	defaultOpenTimeout = 5 * time.Second
+)
+
+// ErrCircuitOpen is returned for Redis commands that are not sent because the circuit breaker is open.
+var ErrCircuitOpen = errors.New("redis circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed sends every command to Redis.
+	CircuitClosed CircuitState = iota
+
+	// CircuitHalfOpen sends a single probe command to Redis and fails the rest until the probe completes.
+	CircuitHalfOpen
+
+	// CircuitOpen fails every command without sending it.
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreakerOptions configures a CircuitBreaker. The zero value uses the defaults.
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failed commands that opens the circuit. Defaults to 5.
+	FailureThreshold int
+
+	// OpenTimeout is how long the circuit stays open before a probe command is let through. Defaults to 5s.
+	OpenTimeout time.Duration
+}
+
+// CircuitBreaker stops sending commands to Redis after repeated failures, so requests fail fast with
+// ErrCircuitOpen, and the policies' failure modes apply, instead of each waiting for a timeout. After OpenTimeout
+// a single probe command is sent, and the circuit closes again once one succeeds.
+//
+// Only errors reaching Redis count as failures, such as refused connections and read or dial timeouts. Error
+// replies from Redis itself, such as NOSCRIPT, do not, and neither do commands canceled by the caller or cut short
+// by the deadline of the caller's context.
+//
+// A CircuitBreaker is a redis.Hook; add it to a client with AddHook. It is safe for concurrent use.
+type CircuitBreaker struct {
+	threshold   int
+	openTimeout time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker.
+func NewCircuitBreaker(opts CircuitBreakerOptions) *CircuitBreaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultFailureThreshold
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = defaultOpenTimeout
+	}
+	return &CircuitBreaker{
+		threshold:   opts.FailureThreshold,
+		openTimeout: opts.OpenTimeout,
+		now:         time.Now,
+	}
+}
+
+// State returns the current state of the circuit.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a command may be sent, moving an open circuit to half-open once OpenTimeout has passed.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return ErrCircuitOpen
+		}
+		b.setState(CircuitHalfOpen)
+		return nil
+	case CircuitHalfOpen:
+		// The probe is still in flight
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+// record updates the circuit with the outcome of a command that was sent with ctx.
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// A probe the caller gave up on says nothing about Redis, so let the next command probe instead
+	if callerGaveUp(ctx, err) {
+		if b.state == CircuitHalfOpen {
+			b.setState(CircuitOpen)
+		}
+		return
+	}
+
+	if !isConnectionFailure(err) {
+		b.failures = 0
+		if b.state != CircuitClosed {
+			b.setState(CircuitClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		if b.state != CircuitOpen {
+			b.setState(CircuitOpen)
+		}
+	}
+}
+
+// setState changes the state of the circuit. b.mu must be held.
+func (b *CircuitBreaker) setState(state CircuitState) {
+	log.Printf("CircuitBreaker: Redis circuit %s -> %s", b.state, state)
+	b.state = state
+}
+
+// callerGaveUp reports whether err comes from the caller canceling ctx or its deadline passing, rather than from
+// Redis. Read and dial timeouts configured on the client are net.Error timeouts, not context.DeadlineExceeded.
+func callerGaveUp(ctx context.Context, err error) bool {
+	return errors.Is(err, context.Canceled) || (errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil)
+}
+
+// isConnectionFailure reports whether err means Redis could not be reached or did not answer in time.
+func isConnectionFailure(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return false
+	}
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
+}
+
+// DialHook passes dials through unchanged; failed dials surface as failed commands.
+func (b *CircuitBreaker) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+// ProcessHook fails the command with ErrCircuitOpen if the circuit is open, and records its outcome otherwise.
+func (b *CircuitBreaker) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := b.allow(); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		err := next(ctx, cmd)
+		b.record(ctx, err)
+		return err
+	}
+}
+
+// ProcessPipelineHook treats a pipeline like a single command.
+func (b *CircuitBreaker) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := b.allow(); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		err := next(ctx, cmds)
+		b.record(ctx, err)
+		return err
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBreaker(threshold int) (*CircuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)}
+	b := NewCircuitBreaker(CircuitBreakerOptions{FailureThreshold: threshold, OpenTimeout: 5 * time.Second})
+	b.now = clock.Now
+	return b, clock
+}
+
+// process sends a command through the breaker's hook to a Redis that replies with err.
+func process(b *CircuitBreaker, err error) (sent bool, got error) {
+	hook := b.ProcessHook(func(context.Context, redis.Cmder) error {
+		sent = true
+		return err
+	})
+	return sent, hook(context.Background(), redis.NewCmd(context.Background(), "ping"))
+}
+
+func TestCircuitBreaker_OpensAfterConsecutiveFailures(t *testing.T) {
+	// Arrange
+	b, _ := newTestBreaker(3)
+	refused := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+	// Act
+	process(b, refused)
+	process(b, refused)
+	stillClosed := b.State()
+	process(b, refused)
+	sent, err := process(b, nil)
+
+	// Assert
+	assert.Equal(t, CircuitClosed, stillClosed)
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.False(t, sent, "Commands should not be sent while the circuit is open")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
+
+func TestCircuitBreaker_IgnoresRedisErrorReplies(t *testing.T) {
+	// Arrange
+	b, _ := newTestBreaker(2)
+
+	// Act
+	process(b, redisError("NOSCRIPT No matching script"))
+	process(b, redis.Nil)
+	process(b, context.Canceled)
+	process(b, redisError("NOSCRIPT No matching script"))
+
+	// Assert
+	assert.Equal(t, CircuitClosed, b.State())
+}
+
+func TestCircuitBreaker_DeadlineExceeded(t *testing.T) {
+	tests := []struct {
+		name    string
+		callCtx func() context.Context
+		want    CircuitState
+	}{
+		{"caller's deadline passed", func() context.Context {
+			ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+			t.Cleanup(cancel)
+			return ctx
+		}, CircuitClosed},
+		{"caller's context still live", context.Background, CircuitOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			b, _ := newTestBreaker(2)
+			hook := b.ProcessHook(func(context.Context, redis.Cmder) error {
+				return context.DeadlineExceeded
+			})
+			ctx := tt.callCtx()
+
+			// Act
+			_ = hook(ctx, redis.NewCmd(ctx, "ping"))
+			_ = hook(ctx, redis.NewCmd(ctx, "ping"))
+
+			// Assert
+			assert.Equal(t, tt.want, b.State())
+		})
+	}
+}
+
+func TestCircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	// Arrange
+	b, _ := newTestBreaker(2)
+	timeout := errors.New("i/o timeout")
+
+	// Act
+	process(b, timeout)
+	process(b, nil)
+	process(b, timeout)
+
+	// Assert
+	assert.Equal(t, CircuitClosed, b.State(), "Only consecutive failures should open the circuit")
+}
+
+func TestCircuitBreaker_HalfOpenProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		probeErr error
+		want     CircuitState
+	}{
+		{"success closes", nil, CircuitClosed},
+		{"failure reopens", errors.New("i/o timeout"), CircuitOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			b, clock := newTestBreaker(1)
+			process(b, errors.New("i/o timeout"))
+			clock.Advance(5 * time.Second)
+
+			// Act
+			var concurrentSent bool
+			probe := b.ProcessHook(func(context.Context, redis.Cmder) error {
+				// A second command arriving while the probe is in flight
+				concurrentSent, _ = process(b, nil)
+				return tt.probeErr
+			})
+			_ = probe(context.Background(), redis.NewCmd(context.Background(), "ping"))
+
+			// Assert
+			assert.False(t, concurrentSent, "Only one probe should be sent while half-open")
+			assert.Equal(t, tt.want, b.State())
+		})
+	}
+}
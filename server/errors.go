@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+)
+
+// BackendError reports that the backend failed while handling a key, so whether the request fits within its
+// limit is unknown. Callers should not treat it as a denial.
+type BackendError struct {
+	// Op is the RateLimiter method that failed, e.g. "CheckAndConsumeTokens".
+	Op string
+
+	// Key is the key being handled, or empty if the operation is not about a single key.
+	Key string
+
+	// Err is the error returned by the backend.
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s: backend error: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: backend error: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// AlgorithmError reports that an operation is not supported by the algorithm of the policy matching a key.
+type AlgorithmError struct {
+	// Op is the RateLimiter method that was called.
+	Op string
+
+	// Key is the key whose policy does not support Op.
+	Key string
+
+	// Policy is the name of the policy matching Key.
+	Policy string
+
+	// Algorithm is the policy's algorithm, and Required the algorithm Op needs.
+	Algorithm, Required Algorithm
+}
+
+func (e *AlgorithmError) Error() string {
+	return fmt.Sprintf("%s %s: policy %s uses %s, but %s requires %s", e.Op, e.Key, e.Policy, e.Algorithm, e.Op, e.Required)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// failClosedRetryAfter is the retry time reported for requests denied because the backend is failing.
+const failClosedRetryAfter = time.Second
+
+// SetFallback sets the backend that policies with FailLocal are enforced against while the primary backend is
+// failing, typically a MemoryBackend. Without a fallback, FailLocal behaves like FailError.
+//
+// nodes is the number of instances sharing the limits. Each enforces its share, 1/nodes of every policy's rate and
+// limits, so together they stay close to the global limit. Tokens consumed from the fallback are replayed into the
+// backend once it recovers; see Replay. It must be called before the RateLimiter is used.
+func (r *RateLimiter) SetFallback(fallback Backend, nodes int) {
+	r.fallback = fallback
+	r.fallbackNodes = max(nodes, 1)
+}
+
+// localCheck returns check with its policy scaled to this instance's share, for the fallback.
+func (r *RateLimiter) localCheck(check Check) Check {
+	check.Policy = check.Policy.perNode(r.fallbackNodes)
+	return check
+}
+
+// failover answers check after the backend failed with err, according to the failure mode of its policy. A
+// *BackendError wrapping err is returned if the policy's failure mode is FailError, or if it is FailLocal and the
+// fallback fails too.
+func (r *RateLimiter) failover(ctx context.Context, op string, check Check, err error) (Result, error) {
+	policy := check.Policy
+	switch policy.FailureMode {
+	case FailOpen:
+		log.Printf("%s: Backend failed for key %s, allowing request: %v", op, check.Key, err)
+		return Result{Allowed: true, Limit: policy.limit()}, nil
+	case FailClosed:
+		log.Printf("%s: Backend failed for key %s, denying request: %v", op, check.Key, err)
+		return Result{Limit: policy.limit(), RetryAfter: failClosedRetryAfter}, nil
+	case FailLocal:
+		if r.fallback == nil {
+			break
+		}
+		log.Printf("%s: Backend failed for key %s, checking local fallback: %v", op, check.Key, err)
+		result, fallbackErr := r.fallback.Check(ctx, r.localCheck(check))
+		if fallbackErr == nil {
+			r.recordFallback([]Check{check}, []Result{result})
+			return result, nil
+		}
+		log.Printf("%s: Local fallback failed for key %s: %v", op, check.Key, fallbackErr)
+	}
+
+	log.Printf("Failed to check key %s: %v", check.Key, err)
+	return Result{}, &BackendError{Op: op, Key: check.Key, Err: err}
+}
+
+// failoverAll answers an all-or-nothing check after the backend failed with err. The strictest failure mode among
+// the checks' policies applies to all of them: FailError, then FailClosed, then FailLocal, then FailOpen.
+func (r *RateLimiter) failoverAll(ctx context.Context, op string, checks []Check, err error) ([]Result, error) {
+	mode := FailOpen
+	for _, c := range checks {
+		if failureModeRank[c.Policy.FailureMode] < failureModeRank[mode] {
+			mode = c.Policy.FailureMode
+		}
+	}
+
+	switch mode {
+	case FailOpen:
+		log.Printf("%s: Backend failed for %d keys, allowing request: %v", op, len(checks), err)
+		results := make([]Result, len(checks))
+		for i, c := range checks {
+			results[i] = Result{Allowed: true, Limit: c.Policy.limit()}
+		}
+		return results, nil
+	case FailClosed:
+		log.Printf("%s: Backend failed for %d keys, denying request: %v", op, len(checks), err)
+		results := make([]Result, len(checks))
+		for i, c := range checks {
+			results[i] = Result{Limit: c.Policy.limit(), RetryAfter: failClosedRetryAfter}
+		}
+		return results, nil
+	case FailLocal:
+		if r.fallback == nil {
+			break
+		}
+		log.Printf("%s: Backend failed for %d keys, checking local fallback: %v", op, len(checks), err)
+		local := make([]Check, len(checks))
+		for i, c := range checks {
+			local[i] = r.localCheck(c)
+		}
+		results, fallbackErr := r.fallback.CheckAllOrNothing(ctx, local)
+		if fallbackErr == nil {
+			r.recordFallback(checks, results)
+			return results, nil
+		}
+		log.Printf("%s: Local fallback failed for %d keys: %v", op, len(checks), fallbackErr)
+	}
+
+	log.Printf("Failed to check %d keys: %v", len(checks), err)
+	return nil, &BackendError{Op: op, Key: checks[0].Key, Err: err}
+}
+
+// failureModeRank orders failure modes from strictest to most permissive.
+var failureModeRank = map[FailureMode]int{
+	FailError:  0,
+	FailClosed: 1,
+	FailLocal:  2,
+	FailOpen:   3,
+}
+
+// failoverLease answers a lease request after the backend failed with err, according to the failure mode of
+// policy. Leases granted by FailOpen are not recorded anywhere, so they never count against the limit.
+func (r *RateLimiter) failoverLease(ctx context.Context, key string, policy Policy, leaseID string, err error) (LeaseState, error) {
+	switch policy.FailureMode {
+	case FailOpen:
+		log.Printf("AcquireLease: Backend failed for key %s, granting lease: %v", key, err)
+		return LeaseState{Acquired: true, ExpiresAt: time.Now().Add(policy.LeaseTTL)}, nil
+	case FailClosed:
+		log.Printf("AcquireLease: Backend failed for key %s, refusing lease: %v", key, err)
+		return LeaseState{}, nil
+	case FailLocal:
+		if r.fallback == nil {
+			break
+		}
+		log.Printf("AcquireLease: Backend failed for key %s, acquiring from local fallback: %v", key, err)
+		state, fallbackErr := r.fallback.AcquireLease(ctx, key, policy.perNode(r.fallbackNodes), leaseID)
+		if fallbackErr == nil {
+			return state, nil
+		}
+		log.Printf("AcquireLease: Local fallback failed for key %s: %v", key, fallbackErr)
+	}
+
+	log.Printf("Failed to acquire lease for key %s: %v", key, err)
+	return LeaseState{}, &BackendError{Op: "AcquireLease", Key: key, Err: err}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingBackend is a Backend whose every call fails.
+type failingBackend struct {
+	err error
+}
+
+func (b failingBackend) Check(context.Context, Check) (Result, error) {
+	return Result{}, b.err
+}
+
+func (b failingBackend) CheckBatch(_ context.Context, checks []Check) ([]Result, []error) {
+	errs := make([]error, len(checks))
+	for i := range errs {
+		errs[i] = b.err
+	}
+	return make([]Result, len(checks)), errs
+}
+
+func (b failingBackend) CheckAllOrNothing(context.Context, []Check) ([]Result, error) {
+	return nil, b.err
+}
+
+func (b failingBackend) Refill(context.Context, string, Policy, int) (int, error) {
+	return 0, b.err
+}
+
+func (b failingBackend) AcquireLease(context.Context, string, Policy, string) (LeaseState, error) {
+	return LeaseState{}, b.err
+}
+
+func (b failingBackend) ReleaseLease(context.Context, string, string) (bool, int, error) {
+	return false, 0, b.err
+}
+
+func (b failingBackend) LeaseCount(context.Context, string) (int, error) {
+	return 0, b.err
+}
+
+func (b failingBackend) SetTokens(context.Context, string, Policy, int) error {
+	return b.err
+}
+
+func (b failingBackend) Delete(context.Context, string, Policy) (bool, error) {
+	return false, b.err
+}
+
+func (b failingBackend) BucketCount(context.Context) (int64, error) {
+	return 0, b.err
+}
+
+// newFailingLimiter returns a RateLimiter whose backend is down, with a MemoryBackend as its fallback.
+func newFailingLimiter(t *testing.T) *RateLimiter {
+	t.Helper()
+	fallback := NewMemoryBackend(MemoryOptions{})
+	t.Cleanup(func() { fallback.Close() })
+
+	policies, err := NewPolicySet(DefaultPolicy(), []Policy{
+		{Name: "open", Prefix: "open:", Capacity: 5, RefillRate: 1, FailureMode: FailOpen},
+		{Name: "closed", Prefix: "closed:", Capacity: 5, RefillRate: 1, FailureMode: FailClosed},
+		{Name: "local", Prefix: "local:", Capacity: 2, RefillRate: 0, MaxConcurrent: 1, FailureMode: FailLocal},
+	})
+	require.NoError(t, err)
+
+	rateLimiter := NewRateLimiterWithBackend(failingBackend{err: errors.New("connection refused")}, policies)
+	rateLimiter.SetFallback(fallback, 1)
+	return rateLimiter
+}
+
+func TestCheckAndConsumeTokens_FailureModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    Result
+		wantErr bool
+	}{
+		{"error by default", "user:1", Result{}, true},
+		{"fail open allows", "open:1", Result{Allowed: true, Limit: 5}, false},
+		{"fail closed denies", "closed:1", Result{Limit: 5, RetryAfter: failClosedRetryAfter}, false},
+		{"local checks the fallback", "local:1", Result{Allowed: true, Remaining: 1, Limit: 2, ResetAfter: -1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rateLimiter := newFailingLimiter(t)
+
+			// Act
+			result, err := rateLimiter.CheckAndConsumeTokens(context.Background(), tt.key, 1)
+
+			// Assert
+			if tt.wantErr {
+				var backendErr *BackendError
+				require.ErrorAs(t, err, &backendErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, result)
+		})
+	}
+}
+
+func TestCheckAndConsumeTokens_LocalFallbackEnforcesLimit(t *testing.T) {
+	// Arrange
+	rateLimiter := newFailingLimiter(t)
+	ctx := context.Background()
+
+	// Act
+	var allowed []bool
+	for range 3 {
+		result, err := rateLimiter.CheckAndConsumeTokens(ctx, "local:1", 1)
+		require.NoError(t, err)
+		allowed = append(allowed, result.Allowed)
+	}
+
+	// Assert
+	assert.Equal(t, []bool{true, true, false}, allowed)
+}
+
+func TestCheckAndConsumeTokens_LocalWithoutFallback(t *testing.T) {
+	// Arrange
+	policies, err := NewPolicySet(Policy{Name: "default", Capacity: 5, RefillRate: 1, FailureMode: FailLocal}, nil)
+	require.NoError(t, err)
+	rateLimiter := NewRateLimiterWithBackend(failingBackend{err: errors.New("connection refused")}, policies)
+
+	// Act
+	_, err = rateLimiter.CheckAndConsumeTokens(context.Background(), "user:1", 1)
+
+	// Assert
+	assert.ErrorAs(t, err, new(*BackendError), "Without a fallback, local should behave like error")
+}
+
+func TestCheckAndConsumeTokensBatch_FailureModes(t *testing.T) {
+	// Arrange
+	rateLimiter := newFailingLimiter(t)
+
+	// Act
+	results, errs := rateLimiter.CheckAndConsumeTokensBatch(context.Background(), []Descriptor{
+		{Key: "open:1", TokenCost: 1},
+		{Key: "user:1", TokenCost: 1},
+		{Key: "closed:1", TokenCost: 1},
+	})
+
+	// Assert
+	var backendErr *BackendError
+	require.ErrorAs(t, errs[1], &backendErr)
+	assert.Equal(t, "user:1", backendErr.Key)
+	assert.NoError(t, errs[0])
+	assert.True(t, results[0].Allowed, "Each descriptor should fail over with its own policy")
+	assert.NoError(t, errs[2])
+	assert.False(t, results[2].Allowed)
+}
+
+func TestCheckAndConsumeTokensAllOrNothing_StrictestFailureModeApplies(t *testing.T) {
+	tests := []struct {
+		name        string
+		keys        []string
+		wantAllowed bool
+		wantErr     bool
+	}{
+		{"all open", []string{"open:1", "open:2"}, true, false},
+		{"closed beats open", []string{"open:1", "closed:1"}, false, false},
+		{"local beats open", []string{"open:1", "local:1"}, true, false},
+		{"error beats everything", []string{"open:1", "closed:1", "user:1"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rateLimiter := newFailingLimiter(t)
+			descriptors := make([]Descriptor, len(tt.keys))
+			for i, key := range tt.keys {
+				descriptors[i] = Descriptor{Key: key, TokenCost: 1}
+			}
+
+			// Act
+			results, err := rateLimiter.CheckAndConsumeTokensAllOrNothing(context.Background(), descriptors)
+
+			// Assert
+			if tt.wantErr {
+				assert.ErrorAs(t, err, new(*BackendError))
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, results, len(tt.keys))
+			for _, result := range results {
+				assert.Equal(t, tt.wantAllowed, result.Allowed)
+			}
+		})
+	}
+}
+
+func TestAcquireLease_FailureModes(t *testing.T) {
+	// Arrange
+	rateLimiter := newFailingLimiter(t)
+	ctx := context.Background()
+
+	// Act
+	open, openErr := rateLimiter.AcquireLease(ctx, "open:1")
+	closed, closedErr := rateLimiter.AcquireLease(ctx, "closed:1")
+	local, localErr := rateLimiter.AcquireLease(ctx, "local:1")
+	full, fullErr := rateLimiter.AcquireLease(ctx, "local:1")
+	released, _, releaseErr := rateLimiter.ReleaseLease(ctx, "local:1", local.ID)
+	_, defaultErr := rateLimiter.AcquireLease(ctx, "user:1")
+
+	// Assert
+	require.NoError(t, openErr)
+	require.NoError(t, closedErr)
+	require.NoError(t, localErr)
+	require.NoError(t, fullErr)
+	require.NoError(t, releaseErr)
+	assert.True(t, open.Acquired)
+	assert.WithinDuration(t, time.Now().Add(defaultLeaseTTL), open.ExpiresAt, time.Second)
+	assert.False(t, closed.Acquired)
+	assert.True(t, local.Acquired)
+	assert.False(t, full.Acquired, "The local fallback should enforce max_concurrent")
+	assert.True(t, released, "Local leases should be released from the fallback")
+	assert.ErrorAs(t, defaultErr, new(*BackendError))
+}
+
+func TestReleaseLease_BackendLeaseDuringOutage(t *testing.T) {
+	// Arrange
+	rateLimiter, backend := newFlakyLimiter(t, 1)
+	ctx := context.Background()
+	remote, err := rateLimiter.AcquireLease(ctx, "local:1")
+	require.NoError(t, err)
+	backend.down.Store(true)
+	local, err := rateLimiter.AcquireLease(ctx, "local:1")
+	require.NoError(t, err)
+
+	// Act
+	_, _, remoteErr := rateLimiter.ReleaseLease(ctx, "local:1", remote.ID)
+	localReleased, _, localErr := rateLimiter.ReleaseLease(ctx, "local:1", local.ID)
+	backend.down.Store(false)
+	remoteReleased, inUse, err := rateLimiter.ReleaseLease(ctx, "local:1", remote.ID)
+	require.NoError(t, err)
+
+	// Assert
+	require.True(t, remote.Acquired)
+	require.True(t, local.Acquired)
+	assert.ErrorAs(t, remoteErr, new(*BackendError), "A lease held by the backend should not be reported as released")
+	assert.NoError(t, localErr)
+	assert.True(t, localReleased, "Local leases should still be released from the fallback")
+	assert.True(t, remoteReleased, "The backend should still hold the lease, so the release can be retried")
+	assert.Zero(t, inUse)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fixedWindowLua counts tokens consumed in the current window with INCRBY, refusing requests that would take the
+// count over the limit. The counter expires when its window ends. It returns {allowed, remaining}.
+//
+// KEYS[1] - counter key for the current window
+// ARGV[1] - limit
+// ARGV[2] - token cost
+// ARGV[3] - time until the window ends in milliseconds
+const fixedWindowLua = `
+local limit = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+
+local count = tonumber(redis.call('GET', KEYS[1])) or 0
+if cost <= 0 then
+  return {1, math.max(0, limit - count)}
+end
+if count + cost > limit then
+  return {0, math.max(0, limit - count)}
+end
+
+count = redis.call('INCRBY', KEYS[1], cost)
+if count == cost then
+  redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return {1, limit - count}
+`
+
+var fixedWindowScript = redis.NewScript(fixedWindowLua)
+
+// windowStart returns the start of the fixed window containing now for key.
+func windowStart(key string, policy Policy, now time.Time) time.Time {
+	window := policy.Window.Milliseconds()
+	var offset int64
+	if !policy.CalendarAligned {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		offset = int64(h.Sum64() % uint64(window))
+	}
+
+	ms := now.UnixMilli() - offset
+	return time.UnixMilli(ms - ms%window + offset)
+}
+
+// checkFixedWindow consumes tokens from a fixed-window counter stored at rl:window:<window-start>:{<key>}, with
+// the window start in Unix milliseconds. Window boundaries are computed from the local clock.
+func (b *RedisBackend) checkFixedWindow(key string, policy Policy, tokenCost int) scriptCall {
+	now := b.now()
+	resetAfter := windowStart(key, policy, now).Add(policy.Window).Sub(now)
+
+	return scriptCall{
+		script: fixedWindowScript,
+		keys:   []string{fixedWindowKey(key, policy, now)},
+		args:   []interface{}{policy.Limit, tokenCost, resetAfter.Milliseconds() + 1},
+		result: func(res []int64) Result {
+			result := Result{Allowed: res[0] == 1, Remaining: int(res[1]), Limit: policy.Limit, ResetAfter: resetAfter}
+			if !result.Allowed {
+				result.RetryAfter = resetAfter
+				if tokenCost > policy.Limit {
+					result.RetryAfter = NeverAllowed
+				}
+			}
+			return result
+		},
+	}
+}
+
+// fixedWindowKey returns the key of the counter for the fixed window containing now.
+func fixedWindowKey(key string, policy Policy, now time.Time) string {
+	return bucketKey(key, "window", strconv.FormatInt(windowStart(key, policy, now).UnixMilli(), 10))
+}
+
+// setFixedWindow sets the counter of the current window to limit - tokens, expiring when the window ends. A
+// counter of zero is deleted instead.
+func (b *RedisBackend) setFixedWindow(ctx context.Context, key string, policy Policy, tokens int) error {
+	now := b.now()
+	counterKey := fixedWindowKey(key, policy, now)
+	count := policy.Limit - tokens
+	if count <= 0 {
+		return b.client.Del(ctx, counterKey).Err()
+	}
+
+	resetAfter := windowStart(key, policy, now).Add(policy.Window).Sub(now)
+	return b.client.Set(ctx, counterKey, count, resetAfter+time.Millisecond).Err()
+}
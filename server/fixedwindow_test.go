@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckFixedWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		steps  []step
+	}{
+		{
+			name:   "calendar aligned windows reset at midnight UTC",
+			policy: Policy{Name: "exports", Prefix: "export:", Algorithm: FixedWindow, Limit: 3, Window: 24 * time.Hour, CalendarAligned: true},
+			steps: []step{
+				// The clock starts at 18:00 UTC, six hours before the window ends
+				{cost: 2, want: Result{Allowed: true, Remaining: 1, Limit: 3, ResetAfter: 6 * time.Hour}, ttl: 6*time.Hour + time.Millisecond},
+				{advance: time.Hour, cost: 2, want: Result{Remaining: 1, Limit: 3, RetryAfter: 5 * time.Hour, ResetAfter: 5 * time.Hour}, ttl: 5*time.Hour + time.Millisecond},
+				{cost: 4, want: Result{Remaining: 1, Limit: 3, RetryAfter: NeverAllowed, ResetAfter: 5 * time.Hour}, ttl: 5*time.Hour + time.Millisecond},
+				{advance: 5 * time.Hour, cost: 3, want: Result{Allowed: true, Remaining: 0, Limit: 3, ResetAfter: 24 * time.Hour}, ttl: 24*time.Hour + time.Millisecond},
+			},
+		},
+		{
+			name:   "empty windows store nothing",
+			policy: Policy{Name: "exports", Prefix: "export:", Algorithm: FixedWindow, Limit: 3, Window: time.Hour, CalendarAligned: true},
+			steps: []step{
+				{cost: 0, want: Result{Allowed: true, Remaining: 3, Limit: 3, ResetAfter: time.Hour}},
+				{advance: 15 * time.Minute, cost: 3, want: Result{Allowed: true, Remaining: 0, Limit: 3, ResetAfter: 45 * time.Minute}, ttl: 45*time.Minute + time.Millisecond},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rateLimiter, _, clock := newRedisLimiter(t, tt.policy)
+			runSteps(t, rateLimiter, clock, "export:tenant:1", tt.steps)
+		})
+	}
+}
+
+func TestWindowStart(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 42, 17, 0, time.UTC)
+
+	// Calendar aligned windows start on UTC boundaries
+	hourly := Policy{Window: time.Hour, CalendarAligned: true}
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), windowStart("k", hourly, now).UTC())
+
+	daily := Policy{Window: 24 * time.Hour, CalendarAligned: true}
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), windowStart("k", daily, now).UTC())
+
+	// Unaligned windows are staggered per key but still contain now
+	unaligned := Policy{Window: time.Hour}
+	for _, key := range []string{"a", "b", "tenant:1"} {
+		start := windowStart(key, unaligned, now)
+		assert.False(t, start.After(now), "window for %s should start before now", key)
+		assert.True(t, start.Add(time.Hour).After(now), "window for %s should end after now", key)
+		assert.Equal(t, start, windowStart(key, unaligned, start), "window for %s should be stable within the window", key)
+	}
+	assert.NotEqual(t, windowStart("a", unaligned, now), windowStart("b", unaligned, now), "different keys should reset at different times")
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcraLua implements the generic cell rate algorithm. The only state is the theoretical arrival time (TAT)
+// of the next request in microseconds; a request is allowed if, after adding its cost in emission intervals,
+// the TAT is no further ahead of now than the burst tolerance. It returns
+// {allowed, remaining, retry_after_us, reset_after_us}, with retry_after_us set to -1 when the cost exceeds
+// the burst and can never be allowed.
+//
+// KEYS[1] - TAT key
+// ARGV[1] - burst
+// ARGV[2] - rate in tokens per second
+// ARGV[3] - token cost
+const gcraLua = `
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = math.max(tonumber(ARGV[3]), 0)
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local interval = 1000000 / rate
+local tolerance = interval * burst
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+  tat = now
+end
+
+local new_tat = tat + interval * cost
+local diff = now - (new_tat - tolerance)
+
+if diff < 0 then
+  local retry_after = -diff
+  if cost > burst then
+    retry_after = -1
+  end
+  local remaining = math.floor((now - (tat - tolerance)) / interval)
+  return {0, remaining, retry_after, tat - now}
+end
+
+local reset_after = new_tat - now
+if cost > 0 and reset_after > 0 then
+  redis.call('SET', KEYS[1], string.format('%d', new_tat), 'PX', math.ceil(reset_after / 1000))
+end
+
+return {1, math.floor(diff / interval), 0, reset_after}
+`
+
+// gcraSetLua stores the TAT at which exactly tokens can be consumed now: the burst minus tokens emission intervals
+// ahead of now. A TAT that is not ahead of now is the initial state, so it is deleted instead.
+//
+// KEYS[1] - TAT key
+// ARGV[1] - burst
+// ARGV[2] - rate in tokens per second
+// ARGV[3] - number of tokens
+const gcraSetLua = `
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local tokens = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local ahead = (burst - tokens) * 1000000 / rate
+if ahead <= 0 then
+  redis.call('DEL', KEYS[1])
+else
+  redis.call('SET', KEYS[1], string.format('%d', now + ahead), 'PX', math.ceil(ahead / 1000))
+end
+return 1
+`
+
+var (
+	gcraScript    = redis.NewScript(gcraLua)
+	gcraSetScript = redis.NewScript(gcraSetLua)
+)
+
+// checkGCRA consumes tokens from a GCRA limit. It enforces the same limit as a token bucket with the policy's
+// capacity as the burst and refill rate as the rate, but stores a single timestamp per key and reports exactly
+// when a denied request may be retried.
+func (b *RedisBackend) checkGCRA(key string, policy Policy, tokenCost int) scriptCall {
+	return scriptCall{
+		script: gcraScript,
+		keys:   []string{bucketKey(key, "tat")},
+		args:   []interface{}{policy.Capacity, policy.RefillRate, tokenCost},
+		result: func(res []int64) Result {
+			return Result{
+				Allowed:    res[0] == 1,
+				Remaining:  int(res[1]),
+				Limit:      policy.Capacity,
+				RetryAfter: retryAfter(res[2], time.Microsecond),
+				ResetAfter: time.Duration(res[3]) * time.Microsecond,
+			}
+		},
+	}
+}
+
+// setGCRA stores the TAT of a GCRA limit with tokens left in its burst.
+func (b *RedisBackend) setGCRA(ctx context.Context, key string, policy Policy, tokens int) error {
+	return gcraSetScript.Run(ctx, b.client, []string{bucketKey(key, "tat")}, policy.Capacity, policy.RefillRate, tokens).Err()
+}
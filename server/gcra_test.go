@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckGCRA(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		steps  []step
+	}{
+		{
+			name:   "burst then one token per emission interval",
+			policy: Policy{Name: "smooth", Prefix: "svc:", Algorithm: GCRA, Capacity: 5, RefillRate: 1},
+			steps: []step{
+				{cost: 1, want: Result{Allowed: true, Remaining: 4, Limit: 5, ResetAfter: time.Second}, ttl: time.Second},
+				{cost: 4, want: Result{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: 5 * time.Second}, ttl: 5 * time.Second},
+				{cost: 1, want: Result{Remaining: 0, Limit: 5, RetryAfter: time.Second, ResetAfter: 5 * time.Second}, ttl: 5 * time.Second},
+				{advance: 2 * time.Second, cost: 2, want: Result{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: 5 * time.Second}, ttl: 5 * time.Second},
+				{cost: 6, want: Result{Remaining: 0, Limit: 5, RetryAfter: NeverAllowed, ResetAfter: 5 * time.Second}, ttl: 5 * time.Second},
+				// The TAT expires once it is no longer ahead of now, which is the initial state
+				{advance: 5 * time.Second, cost: 0, want: Result{Allowed: true, Remaining: 5, Limit: 5}},
+			},
+		},
+		{
+			name:   "denied requests report the exact retry time",
+			policy: Policy{Name: "smooth", Prefix: "svc:", Algorithm: GCRA, Capacity: 1, RefillRate: 2},
+			steps: []step{
+				{cost: 1, want: Result{Allowed: true, Remaining: 0, Limit: 1, ResetAfter: 500 * time.Millisecond}, ttl: 500 * time.Millisecond},
+				{advance: 200 * time.Millisecond, cost: 1, want: Result{Remaining: 0, Limit: 1, RetryAfter: 300 * time.Millisecond, ResetAfter: 300 * time.Millisecond}, ttl: 300 * time.Millisecond},
+				{advance: 300 * time.Millisecond, cost: 1, want: Result{Allowed: true, Remaining: 0, Limit: 1, ResetAfter: 500 * time.Millisecond}, ttl: 500 * time.Millisecond},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rateLimiter, _, clock := newRedisLimiter(t, tt.policy)
+			runSteps(t, rateLimiter, clock, "svc:export", tt.steps)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// acquireLeaseLua keeps the leases held for a key in a sorted set scored by expiry time in milliseconds.
+// Expired leases are dropped before counting, so slots held by crashed clients free themselves.
+// It returns {acquired, in_use, expires_at_ms}.
+//
+// KEYS[1] - lease set key
+// ARGV[1] - maximum concurrent leases, 0 for unlimited
+// ARGV[2] - lease TTL in milliseconds
+// ARGV[3] - lease ID
+const acquireLeaseLua = `
+local limit = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+local in_use = redis.call('ZCARD', KEYS[1])
+if limit > 0 and in_use >= limit then
+  return {0, in_use, 0}
+end
+
+local expires_at = now + ttl
+redis.call('ZADD', KEYS[1], expires_at, ARGV[3])
+if redis.call('PTTL', KEYS[1]) < ttl then
+  redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return {1, in_use + 1, expires_at}
+`
+
+// releaseLeaseLua removes a lease and drops expired ones. It returns {released, in_use}.
+//
+// KEYS[1] - lease set key
+// ARGV[1] - lease ID
+const releaseLeaseLua = `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+local released = redis.call('ZREM', KEYS[1], ARGV[1])
+return {released, redis.call('ZCARD', KEYS[1])}
+`
+
+// leaseCountLua counts the leases that have not expired, leaving expired ones for the next acquire or release to
+// drop. It returns in_use.
+//
+// KEYS[1] - lease set key
+const leaseCountLua = `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+return redis.call('ZCOUNT', KEYS[1], '(' .. now, '+inf')
+`
+
+var (
+	acquireLeaseScript = redis.NewScript(acquireLeaseLua)
+	releaseLeaseScript = redis.NewScript(releaseLeaseLua)
+	leaseCountScript   = redis.NewScript(leaseCountLua)
+)
+
+// Lease is the outcome of trying to acquire a slot in a key's concurrency limit.
+type Lease struct {
+	// Acquired reports whether a slot was acquired.
+	Acquired bool
+
+	// ID identifies the lease when releasing it. Empty if the lease was not acquired.
+	ID string
+
+	// InUse is the number of leases held for the key, including this one if it was acquired.
+	InUse int
+
+	// Limit is the maximum number of concurrent leases for the key, 0 if unlimited.
+	Limit int
+
+	// ExpiresAt is when the lease expires if it is not released.
+	ExpiresAt time.Time
+}
+
+// newLeaseID returns a random lease ID.
+func newLeaseID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AcquireLease takes one of the concurrent slots allowed by the policy matching key. The lease is held until it
+// is released or its TTL expires. If the backend fails, the policy's failure mode decides whether the lease is
+// granted; with the default FailError a *BackendError is returned.
+func (r *RateLimiter) AcquireLease(ctx context.Context, key string) (Lease, error) {
+	policy := r.Policies().Match(key)
+	id := newLeaseID()
+	log.Printf("AcquireLease: Acquiring lease for key %s using policy %s", key, policy.Name)
+
+	state, err := r.backend.AcquireLease(ctx, key, policy, id)
+	if err != nil {
+		if state, err = r.failoverLease(ctx, key, policy, id, err); err != nil {
+			return Lease{Limit: policy.MaxConcurrent}, err
+		}
+	}
+
+	lease := Lease{InUse: state.InUse, Limit: policy.MaxConcurrent}
+	if !state.Acquired {
+		log.Printf("AcquireLease: No slots available for key %s. In use: %d, Limit: %d", key, lease.InUse, lease.Limit)
+		return lease, nil
+	}
+
+	lease.Acquired = true
+	lease.ID = id
+	lease.ExpiresAt = state.ExpiresAt
+	log.Printf("AcquireLease: Acquired lease %s for key %s, %d leases in use", id, key, lease.InUse)
+	return lease, nil
+}
+
+// ReleaseLease frees the slot held by a lease. Returns whether the lease was still held and the number of
+// leases held for the key afterwards, or a *BackendError if the backend fails. Leases of policies using FailLocal
+// are released from the local fallback instead while the backend is failing. A lease the fallback does not hold
+// was acquired from the backend, or has expired, so the *BackendError is returned rather than reporting the lease
+// as gone while the backend may still hold its slot.
+func (r *RateLimiter) ReleaseLease(ctx context.Context, key string, leaseID string) (bool, int, error) {
+	released, inUse, err := r.backend.ReleaseLease(ctx, key, leaseID)
+	if err != nil && r.fallback != nil && r.Policies().Match(key).FailureMode == FailLocal {
+		log.Printf("ReleaseLease: Backend failed for key %s, releasing from local fallback: %v", key, err)
+		if localReleased, localInUse, localErr := r.fallback.ReleaseLease(ctx, key, leaseID); localErr != nil {
+			log.Printf("ReleaseLease: Local fallback failed for key %s: %v", key, localErr)
+		} else if localReleased {
+			released, inUse, err = true, localInUse, nil
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to release lease %s for key %s: %v", leaseID, key, err)
+		return false, 0, &BackendError{Op: "ReleaseLease", Key: key, Err: err}
+	}
+
+	if !released {
+		log.Printf("ReleaseLease: Lease %s for key %s had already expired or been released", leaseID, key)
+	} else {
+		log.Printf("ReleaseLease: Released lease %s for key %s, %d leases in use", leaseID, key, inUse)
+	}
+	return released, inUse, nil
+}
+
+// AcquireLease runs the acquire script against the key's lease set.
+func (b *RedisBackend) AcquireLease(ctx context.Context, key string, policy Policy, leaseID string) (LeaseState, error) {
+	res, err := acquireLeaseScript.Run(ctx, b.client, []string{bucketKey(key, "leases")}, policy.MaxConcurrent, policy.LeaseTTL.Milliseconds(), leaseID).Int64Slice()
+	if err != nil {
+		return LeaseState{}, err
+	}
+
+	state := LeaseState{Acquired: res[0] == 1, InUse: int(res[1])}
+	if state.Acquired {
+		state.ExpiresAt = time.UnixMilli(res[2])
+	}
+	return state, nil
+}
+
+// ReleaseLease runs the release script against the key's lease set.
+func (b *RedisBackend) ReleaseLease(ctx context.Context, key string, leaseID string) (bool, int, error) {
+	res, err := releaseLeaseScript.Run(ctx, b.client, []string{bucketKey(key, "leases")}, leaseID).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, int(res[1]), nil
+}
+
+// LeaseCount runs the lease count script against the key's lease set.
+func (b *RedisBackend) LeaseCount(ctx context.Context, key string) (int, error) {
+	inUse, err := leaseCountScript.Run(ctx, b.client, []string{bucketKey(key, "leases")}).Int()
+	if err != nil {
+		return 0, err
+	}
+	return inUse, nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeases(t *testing.T) {
+	// Arrange
+	rateLimiter, mr, clock := newRedisLimiter(t, Policy{Name: "jobs", Prefix: "jobs:", Capacity: 10, MaxConcurrent: 2, LeaseTTL: 10 * time.Second})
+	ctx := context.Background()
+	key := "jobs:tenant:1"
+	start := clock.Now()
+
+	// Act
+	first, err := rateLimiter.AcquireLease(ctx, key)
+	require.NoError(t, err)
+	clock.Advance(4 * time.Second)
+	second, err := rateLimiter.AcquireLease(ctx, key)
+	require.NoError(t, err)
+	refused, err := rateLimiter.AcquireLease(ctx, key)
+	require.NoError(t, err)
+	setTTL := mr.TTL(bucketKey(key, "leases"))
+
+	// The first lease expires without being released, freeing its slot
+	clock.Advance(6 * time.Second)
+	third, err := rateLimiter.AcquireLease(ctx, key)
+	require.NoError(t, err)
+
+	released, inUse, err := rateLimiter.ReleaseLease(ctx, key, second.ID)
+	require.NoError(t, err)
+	releasedAgain, inUseAgain, err := rateLimiter.ReleaseLease(ctx, key, second.ID)
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, first.Acquired)
+	assert.Len(t, first.ID, 32)
+	assert.Equal(t, 1, first.InUse)
+	assert.Equal(t, 2, first.Limit)
+	assert.True(t, start.Add(10*time.Second).Equal(first.ExpiresAt), "The lease should expire after the lease TTL")
+
+	assert.True(t, second.Acquired)
+	assert.Equal(t, 2, second.InUse)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	assert.Equal(t, Lease{InUse: 2, Limit: 2}, refused, "No slot should be free while both leases are held")
+	assert.Equal(t, 10*time.Second, setTTL, "The lease set should expire with its last lease")
+
+	assert.True(t, third.Acquired, "The expired lease's slot should be reused")
+	assert.Equal(t, 2, third.InUse)
+
+	assert.True(t, released)
+	assert.Equal(t, 1, inUse)
+	assert.False(t, releasedAgain, "Releasing twice should report the lease as gone")
+	assert.Equal(t, 1, inUseAgain)
+}
+
+func TestLeaseCount(t *testing.T) {
+	policy := Policy{Name: "jobs", Prefix: "jobs:", Capacity: 10, MaxConcurrent: 2, LeaseTTL: 10 * time.Second}
+	tests := []struct {
+		name  string
+		setup func(t *testing.T) (*RateLimiter, interface{ Advance(time.Duration) })
+	}{
+		{
+			name: "Redis",
+			setup: func(t *testing.T) (*RateLimiter, interface{ Advance(time.Duration) }) {
+				rateLimiter, _, clock := newRedisLimiter(t, policy)
+				return rateLimiter, clock
+			},
+		},
+		{
+			name: "Memory",
+			setup: func(t *testing.T) (*RateLimiter, interface{ Advance(time.Duration) }) {
+				rateLimiter, _, clock := newMemoryLimiter(t, MemoryOptions{}, policy)
+				return rateLimiter, clock
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rateLimiter, clock := tt.setup(t)
+			ctx := context.Background()
+			key := "jobs:tenant:1"
+
+			// Act
+			none, err := rateLimiter.backend.LeaseCount(ctx, key)
+			require.NoError(t, err)
+			_, err = rateLimiter.AcquireLease(ctx, key)
+			require.NoError(t, err)
+			clock.Advance(4 * time.Second)
+			second, err := rateLimiter.AcquireLease(ctx, key)
+			require.NoError(t, err)
+			both, err := rateLimiter.backend.LeaseCount(ctx, key)
+			require.NoError(t, err)
+			bothAgain, err := rateLimiter.backend.LeaseCount(ctx, key)
+			require.NoError(t, err)
+
+			// The first lease expires without being released
+			clock.Advance(6 * time.Second)
+			afterExpiry, err := rateLimiter.backend.LeaseCount(ctx, key)
+			require.NoError(t, err)
+			released, _, err := rateLimiter.ReleaseLease(ctx, key, second.ID)
+			require.NoError(t, err)
+
+			// Assert
+			assert.Zero(t, none)
+			assert.Equal(t, 2, both)
+			assert.Equal(t, 2, bothAgain, "Counting should not change the leases held")
+			assert.Equal(t, 1, afterExpiry, "Expired leases should not be counted")
+			assert.True(t, released, "Counting should leave unexpired leases in place")
+		})
+	}
+}
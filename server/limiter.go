@@ -0,0 +1,226 @@
+// Package server implements the rate limiter service. Each key is limited by the policy matching it, using
+// a token bucket, GCRA, a sliding window log, a sliding window counter or a fixed window. It provides functionality for checking and consuming tokens,
+// as well as refilling buckets, leasing concurrency slots and inspecting or resetting a key's state. State is kept in Redis, or in process memory for
+// single-node deployments.
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultBucketSize is the capacity of buckets that no configured policy matches.
+	defaultBucketSize = 10
+
+	// defaultRefillRate is the number of tokens per second added back to buckets that no configured policy matches.
+	defaultRefillRate = 1.0
+
+	// defaultLeaseTTL is how long leases are held for policies that do not set a lease TTL.
+	defaultLeaseTTL = 30 * time.Second
+
+	// keyNamespace starts every Redis key written by the rate limiter.
+	keyNamespace = "rl:"
+)
+
+type RateLimiter struct {
+	backend       Backend
+	fallback      Backend
+	fallbackNodes int
+	pending       pendingConsumption
+	policies      atomic.Pointer[PolicySet]
+}
+
+// NewRateLimiter creates a RateLimiter that keeps its state in Redis and sizes and refills buckets according to
+// policies. A nil policies applies DefaultPolicy to every key.
+func NewRateLimiter(redisClient redis.UniversalClient, policies *PolicySet) *RateLimiter {
+	return NewRateLimiterWithBackend(NewRedisBackend(redisClient), policies)
+}
+
+// NewRateLimiterWithBackend creates a RateLimiter that keeps its state in backend and sizes and refills buckets
+// according to policies. A nil policies applies DefaultPolicy to every key.
+func NewRateLimiterWithBackend(backend Backend, policies *PolicySet) *RateLimiter {
+	r := &RateLimiter{backend: backend}
+	r.SetPolicies(policies)
+	return r
+}
+
+// SetPolicies atomically replaces the active policy set. Calls already in progress keep using the
+// set they started with. A nil policies applies DefaultPolicy to every key.
+func (r *RateLimiter) SetPolicies(policies *PolicySet) {
+	if policies == nil {
+		policies = DefaultPolicies()
+	}
+	r.policies.Store(policies)
+}
+
+// Policies returns the active policy set.
+func (r *RateLimiter) Policies() *PolicySet {
+	return r.policies.Load()
+}
+
+// bucketKey returns the Redis key holding the state for key. Algorithms that keep state in a different shape
+// than the token bucket pass a suffix naming it, so switching a key's algorithm never misreads old state. Every
+// key starts with keyNamespace and the kind of state, "bucket" or the suffix, ahead of the user's key, e.g.
+// "rl:tat:{user:1}", so no user key can produce the key of another key's state, whatever it contains.
+//
+// The key is wrapped in a Redis Cluster hash tag, so all of its state lives in one slot. Keys that already contain
+// a hash tag keep it instead, marked with a "#" so they never match a wrapped key. This lets callers place related
+// keys in the same slot, e.g. "{tenant:1}:user:2" and "{tenant:1}", so they can be checked together by a single
+// script.
+func bucketKey(key string, suffix ...string) string {
+	kind := "bucket"
+	if len(suffix) > 0 {
+		kind = strings.Join(suffix, ":")
+	}
+	if hasHashTag(key) {
+		return keyNamespace + kind + ":#" + key
+	}
+	return keyNamespace + kind + ":{" + key + "}"
+}
+
+// hasHashTag reports whether Redis Cluster would hash key by a tag: the non-empty text between its first "{" and
+// the first "}" after it.
+func hasHashTag(key string) bool {
+	open := strings.IndexByte(key, '{')
+	return open >= 0 && strings.IndexByte(key[open+1:], '}') > 0
+}
+
+// Result is the outcome of checking a key against its limit.
+type Result struct {
+	// Allowed reports whether the request can proceed.
+	Allowed bool
+
+	// Remaining is the number of tokens left after the request.
+	Remaining int
+
+	// Limit is the most tokens the key can have available at once: the capacity for TokenBucket and GCRA,
+	// and the per-window limit otherwise.
+	Limit int
+
+	// RetryAfter is how long until the same request would be allowed. It is zero when the request was
+	// allowed, and NeverAllowed when the request can never be allowed, either because its cost exceeds the
+	// limit or because the bucket does not refill.
+	RetryAfter time.Duration
+
+	// ResetAfter is how long until the limit is fully replenished. It is negative when the bucket never
+	// refills on its own.
+	ResetAfter time.Duration
+}
+
+// NeverAllowed is the RetryAfter of a request that can never be allowed, whatever the algorithm and backend.
+const NeverAllowed time.Duration = -1
+
+// retryAfter converts a retry time returned by a Lua script in units of unit to a duration. The scripts return -1
+// for requests that can never be allowed, which maps to NeverAllowed.
+func retryAfter(v int64, unit time.Duration) time.Duration {
+	if v < 0 {
+		return NeverAllowed
+	}
+	return time.Duration(v) * unit
+}
+
+// Descriptor is a key to check together with the number of tokens the request costs.
+type Descriptor struct {
+	Key       string
+	TokenCost int
+}
+
+// checked logs the outcome of a check, or answers it according to its policy's failure mode if the backend failed.
+func (r *RateLimiter) checked(ctx context.Context, op string, check Check, result Result, err error) (Result, error) {
+	if err != nil {
+		return r.failover(ctx, op, check, err)
+	}
+	r.maybeReplay()
+
+	if !result.Allowed {
+		log.Printf("CheckAndConsumeTokens: Not enough tokens for key %s. Required: %d, Available: %d", check.Key, check.TokenCost, result.Remaining)
+		return result, nil
+	}
+
+	log.Printf("CheckAndConsumeTokens: Consumed %d tokens for key %s, %d tokens remaining", max(check.TokenCost, 0), check.Key, result.Remaining)
+	return result, nil
+}
+
+// CheckAndConsumeTokens checks if the request fits within the limit of the policy matching key and consumes
+// tokens if it does. The policy also selects the algorithm used to enforce the limit, and how to answer if the
+// backend fails; with the default FailError a *BackendError is returned, since it is unknown whether the request
+// fits.
+func (r *RateLimiter) CheckAndConsumeTokens(ctx context.Context, key string, tokenCost int) (Result, error) {
+	policy := r.Policies().Match(key)
+	log.Printf("CheckAndConsumeTokens: Checking key %s for %d tokens using %s policy %s", key, tokenCost, policy.Algorithm, policy.Name)
+
+	check := Check{Key: key, Policy: policy, TokenCost: tokenCost}
+	result, err := r.backend.Check(ctx, check)
+	return r.checked(ctx, "CheckAndConsumeTokens", check, result, err)
+}
+
+// CheckAndConsumeTokensBatch checks every descriptor in a single round trip to the backend. Each descriptor is
+// checked and consumed independently of the others, exactly as if CheckAndConsumeTokens had been called for it,
+// and results and errors are returned in the same order as descriptors. If the backend fails for a descriptor whose
+// policy uses FailError, its error is a *BackendError and its result is empty; the other descriptors were still
+// checked and consumed.
+func (r *RateLimiter) CheckAndConsumeTokensBatch(ctx context.Context, descriptors []Descriptor) ([]Result, []error) {
+	return r.CheckAndConsumeTokensBatchWithPolicies(ctx, r.Policies(), descriptors)
+}
+
+// CheckAndConsumeTokensBatchWithPolicies is CheckAndConsumeTokensBatch with descriptors matched against policies
+// rather than the active set, so callers that report the policies applied, e.g. taken from Policies, see the same
+// ones even if the set is replaced during the check.
+func (r *RateLimiter) CheckAndConsumeTokensBatchWithPolicies(ctx context.Context, policies *PolicySet, descriptors []Descriptor) ([]Result, []error) {
+	checks := make([]Check, len(descriptors))
+	for i, d := range descriptors {
+		checks[i] = Check{Key: d.Key, Policy: policies.Match(d.Key), TokenCost: d.TokenCost}
+		log.Printf("CheckAndConsumeTokens: Checking key %s for %d tokens using %s policy %s", d.Key, d.TokenCost, checks[i].Policy.Algorithm, checks[i].Policy.Name)
+	}
+
+	results, errs := r.backend.CheckBatch(ctx, checks)
+	for i := range descriptors {
+		results[i], errs[i] = r.checked(ctx, "CheckAndConsumeTokensBatch", checks[i], results[i], errs[i])
+	}
+	return results, errs
+}
+
+// BucketCount returns the number of live entries holding limiter state in the backend, or a *BackendError if the
+// backend fails.
+func (r *RateLimiter) BucketCount(ctx context.Context) (int64, error) {
+	count, err := r.backend.BucketCount(ctx)
+	if err != nil {
+		return 0, &BackendError{Op: "BucketCount", Err: err}
+	}
+	return count, nil
+}
+
+// RefillTokens manually tops up the bucket with amount tokens, up to the capacity of the policy matching key.
+// Only token bucket policies can be topped up. Buckets already refill over time, so this is only needed to grant tokens ahead of schedule.
+// Returns the new token count, or a *BackendError if the backend fails.
+func (r *RateLimiter) RefillTokens(ctx context.Context, key string, amount int) (int, error) {
+	policy := r.Policies().Match(key)
+
+	// Handle invalid amount, and policies that have no bucket to top up
+	if amount <= 0 || policy.Algorithm != TokenBucket {
+		log.Printf("RefillTokens: Cannot add %d tokens to key %s with %s policy %s, treating as no-op", amount, key, policy.Algorithm, policy.Name)
+		result, err := r.backend.Check(ctx, Check{Key: key, Policy: policy})
+		if err != nil {
+			log.Printf("Failed to refill key %s: %v", key, err)
+			return 0, &BackendError{Op: "RefillTokens", Key: key, Err: err}
+		}
+		return result.Remaining, nil
+	}
+
+	log.Printf("RefillTokens: Attempting to add %d tokens to key %s using policy %s", amount, key, policy.Name)
+
+	newTokens, err := r.backend.Refill(ctx, key, policy, amount)
+	if err != nil {
+		log.Printf("Failed to refill key %s: %v", key, err)
+		return 0, &BackendError{Op: "RefillTokens", Key: key, Err: err}
+	}
+
+	log.Printf("RefillTokens: Successfully refilled key %s, new count: %d", key, newTokens)
+	return newTokens, nil
+}
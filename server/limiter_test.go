@@ -0,0 +1,614 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redisError mimics an error reply from the Redis server.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+func (redisError) RedisError() {}
+
+// redisClock advances the clock of an in-process Redis server, which the scripts read with TIME, together with
+// the clock the Redis backend computes window boundaries from.
+type redisClock struct {
+	fakeClock
+	mr *miniredis.Miniredis
+}
+
+func (c *redisClock) Advance(d time.Duration) {
+	c.fakeClock.Advance(d)
+	c.mr.SetTime(c.Now())
+	c.mr.FastForward(d)
+}
+
+// newRedisLimiter creates a RateLimiter backed by an in-process Redis server, so tests run the Lua scripts
+// themselves rather than canned replies.
+func newRedisLimiter(t *testing.T, policies ...Policy) (*RateLimiter, *miniredis.Miniredis, *redisClock) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	clock := &redisClock{fakeClock: fakeClock{now: time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)}, mr: mr}
+	mr.SetTime(clock.Now())
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	backend := NewRedisBackend(client)
+	backend.now = clock.Now
+
+	set, err := NewPolicySet(DefaultPolicy(), policies)
+	require.NoError(t, err)
+	return NewRateLimiterWithBackend(backend, set), mr, clock
+}
+
+// step is one check in a sequence run by runSteps.
+type step struct {
+	// advance is how far the clock moves before the check.
+	advance time.Duration
+
+	cost int
+	want Result
+
+	// ttl is the expiry of the key's state after the check, or zero if no state should be stored.
+	ttl time.Duration
+}
+
+// runSteps checks key against each step in turn, asserting the result and the expiry of the state the check left
+// in Redis.
+func runSteps(t *testing.T, rateLimiter *RateLimiter, clock *redisClock, key string, steps []step) {
+	t.Helper()
+	policy := rateLimiter.Policies().Match(key)
+	for i, s := range steps {
+		clock.Advance(s.advance)
+		result, err := rateLimiter.CheckAndConsumeTokens(context.Background(), key, s.cost)
+		require.NoError(t, err, "step %d", i)
+		assert.Equal(t, s.want, result, "step %d", i)
+
+		stateKey := memoryKey(Check{Key: key, Policy: policy}, clock.Now())
+		if s.ttl == 0 {
+			assert.False(t, clock.mr.Exists(stateKey), "step %d: no state should be stored", i)
+		} else {
+			assert.Equal(t, s.ttl, clock.mr.TTL(stateKey), "step %d: state should expire once it is no longer needed", i)
+		}
+	}
+}
+
+func TestCheckAndConsumeTokens_NewBucket(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:123"
+	tokenCost := 1
+
+	// Mock the script initializing a bucket with the default size and consuming 1 token
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, tokenCost).
+		SetVal([]interface{}{int64(1), int64(9), int64(0), int64(1_000)})
+
+	// Act
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, tokenCost)
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, result.Allowed, "Request should be allowed for new bucket")
+	assert.Equal(t, 9, result.Remaining, "Should have 9 tokens remaining after consuming 1")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokens_ExistingBucket(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:456"
+	tokenCost := 2
+
+	// Mock the script consuming 2 tokens from a bucket that refilled to 5 tokens
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, tokenCost).
+		SetVal([]interface{}{int64(1), int64(3), int64(0), int64(7_000)})
+
+	// Act
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, tokenCost)
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, result.Allowed, "Request should be allowed")
+	assert.Equal(t, 3, result.Remaining, "Should have 3 tokens remaining after consuming 2")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokens_InsufficientTokens(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:789"
+	tokenCost := 3
+
+	// Mock the script denying a request against a bucket with 2 tokens
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, tokenCost).
+		SetVal([]interface{}{int64(0), int64(2), int64(1_000), int64(8_000)})
+
+	// Act
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, tokenCost)
+	require.NoError(t, err)
+
+	// Assert
+	assert.False(t, result.Allowed, "Request should be denied")
+	assert.Equal(t, 2, result.Remaining, "Should still have 2 tokens")
+	assert.Equal(t, defaultBucketSize, result.Limit)
+	assert.Equal(t, time.Second, result.RetryAfter, "One more token accrues after a second")
+	assert.Equal(t, 8*time.Second, result.ResetAfter)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokens_NoScriptFallback(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:noscript"
+	tokenCost := 1
+
+	// Mock the script cache being empty, which should fall back to EVAL
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, tokenCost).
+		SetErr(redisError("NOSCRIPT No matching script. Please use EVAL."))
+	mock.ExpectEval(checkAndConsumeLua, []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, tokenCost).
+		SetVal([]interface{}{int64(1), int64(9), int64(0), int64(1_000)})
+
+	// Act
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, tokenCost)
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, result.Allowed, "Request should be allowed after reloading the script")
+	assert.Equal(t, 9, result.Remaining)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokens_ScriptError(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:error"
+	tokenCost := 1
+
+	// Mock Redis calls with error
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, tokenCost).
+		SetErr(errors.New("redis connection error"))
+
+	// Act
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, tokenCost)
+
+	// Assert
+	var backendErr *BackendError
+	require.ErrorAs(t, err, &backendErr, "A backend failure should not look like a denial")
+	assert.Equal(t, "CheckAndConsumeTokens", backendErr.Op)
+	assert.Equal(t, key, backendErr.Key)
+	assert.EqualError(t, backendErr.Err, "redis connection error")
+	assert.Equal(t, Result{}, result)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokens_ZeroOrNegativeTokens(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:zerotokens"
+
+	// Test with zero tokens
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, 0).
+		SetVal([]interface{}{int64(1), int64(5), int64(0), int64(5_000)})
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 0)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "Request should be allowed for zero tokens")
+	assert.Equal(t, 5, result.Remaining)
+
+	// Test with negative tokens
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, -1).
+		SetVal([]interface{}{int64(1), int64(5), int64(0), int64(5_000)})
+	result, err = rateLimiter.CheckAndConsumeTokens(ctx, key, -1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "Request should be allowed for negative tokens")
+	assert.Equal(t, 5, result.Remaining)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefillTokens_Normal(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:101"
+	amount := 3
+
+	// Mock the script topping up a bucket with 5 tokens
+	mock.ExpectEvalSha(refillScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, amount).
+		SetVal(int64(8)) // 5 + 3 = 8
+
+	// Act
+	newTokenCount, err := rateLimiter.RefillTokens(ctx, key, amount)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, 8, newTokenCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefillTokens_NoScriptFallback(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:102"
+	amount := 5
+
+	// Mock the script cache being empty, which should fall back to EVAL
+	mock.ExpectEvalSha(refillScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, amount).
+		SetErr(redisError("NOSCRIPT No matching script. Please use EVAL."))
+	mock.ExpectEval(refillLua, []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, amount).
+		SetVal(int64(10)) // Would be 13, capped at 10
+
+	// Act
+	newTokenCount, err := rateLimiter.RefillTokens(ctx, key, amount)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, 10, newTokenCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefillTokens_ScriptError(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:error"
+	amount := 3
+
+	// Mock Redis calls with error
+	mock.ExpectEvalSha(refillScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, amount).
+		SetErr(errors.New("redis connection error"))
+
+	// Act
+	newTokenCount, err := rateLimiter.RefillTokens(ctx, key, amount)
+
+	// Assert
+	var backendErr *BackendError
+	require.ErrorAs(t, err, &backendErr, "A backend failure should not look like a denial")
+	assert.Equal(t, 0, newTokenCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefillTokens_EdgeCases(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:edgecases"
+
+	// Invalid amounts only read the current (refilled) token count
+	for _, amount := range []int{0, -1} {
+		mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, defaultBucketSize, defaultRefillRate, 0).
+			SetVal([]interface{}{int64(1), int64(5), int64(0), int64(5_000)})
+		newTokens, err := rateLimiter.RefillTokens(ctx, key, amount)
+		require.NoError(t, err)
+		assert.Equal(t, 5, newTokens, "amount %d", amount)
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	// Test with valid client
+	client, _ := redismock.NewClientMock()
+	limiter := NewRateLimiter(client, nil)
+	assert.NotNil(t, limiter)
+	assert.Equal(t, client, limiter.backend.(*RedisBackend).client)
+	assert.Equal(t, DefaultPolicy(), limiter.Policies().Match("any"), "Nil policies should fall back to the default")
+
+	// Test with nil client
+	limiter = NewRateLimiter(nil, nil)
+	assert.NotNil(t, limiter)
+	assert.Nil(t, limiter.backend.(*RedisBackend).client)
+}
+
+func TestCheckAndConsumeTokens_UsesMatchingPolicy(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	policies, err := NewPolicySet(DefaultPolicy(), []Policy{
+		{Name: "tenants", Prefix: "tenant:", Capacity: 100, RefillRate: 5},
+	})
+	assert.NoError(t, err)
+	rateLimiter := NewRateLimiter(client, policies)
+	ctx := context.Background()
+	key := "tenant:acme"
+
+	// Mock the script being called with the policy's capacity and refill rate
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, 100, 5.0, 1).
+		SetVal([]interface{}{int64(1), int64(99), int64(0), int64(200)})
+
+	// Act
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 99, result.Remaining)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefillTokens_UsesMatchingPolicy(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	policies, err := NewPolicySet(DefaultPolicy(), []Policy{
+		{Name: "vip", Key: "user:vip", Capacity: 50, RefillRate: 2},
+	})
+	assert.NoError(t, err)
+	rateLimiter := NewRateLimiter(client, policies)
+	ctx := context.Background()
+	key := "user:vip"
+
+	// Mock the script being capped at the policy's capacity
+	mock.ExpectEvalSha(refillScript.Hash(), []string{"rl:bucket:{" + key + "}"}, 50, 2.0, 20).
+		SetVal(int64(50))
+
+	// Act
+	newTokenCount, err := rateLimiter.RefillTokens(ctx, key, 20)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, 50, newTokenCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetPolicies_SwapsActiveSet(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+	key := "user:swap"
+
+	policies, err := NewPolicySet(Policy{Name: "strict", Capacity: 2, RefillRate: 0.1}, nil)
+	assert.NoError(t, err)
+
+	// Act
+	rateLimiter.SetPolicies(policies)
+
+	// Assert - new checks use the new default policy
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{" + key + "}"}, 2, 0.1, 1).
+		SetVal([]interface{}{int64(1), int64(1), int64(0), int64(10_000)})
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	assert.Same(t, policies, rateLimiter.Policies())
+
+	// Assert - a nil set restores the defaults
+	rateLimiter.SetPolicies(nil)
+	assert.Equal(t, DefaultPolicy(), rateLimiter.Policies().Match(key))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokensBatch(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	policies, err := NewPolicySet(DefaultPolicy(), []Policy{
+		{Name: "tenants", Prefix: "tenant:", Capacity: 100, RefillRate: 5},
+		{Name: "ips", Prefix: "ip:", Algorithm: SlidingWindowLog, Limit: 5, Window: time.Minute},
+	})
+	assert.NoError(t, err)
+	rateLimiter := NewRateLimiter(client, policies)
+	ctx := context.Background()
+
+	// Mock each descriptor being checked with its own policy's script in a single pipeline
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{user:1}"}, defaultBucketSize, defaultRefillRate, 1).
+		SetVal([]interface{}{int64(1), int64(9), int64(0), int64(1_000)})
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{tenant:acme}"}, 100, 5.0, 1).
+		SetVal([]interface{}{int64(1), int64(99), int64(0), int64(200)})
+	mock.ExpectEvalSha(slidingWindowLogScript.Hash(), []string{"rl:log:{ip:10.0.0.1}"}, 5, int64(60_000_000), 1).
+		SetVal([]interface{}{int64(0), int64(0), int64(12_000_000), int64(45_000_000)})
+
+	// Act
+	results, errs := rateLimiter.CheckAndConsumeTokensBatch(ctx, []Descriptor{
+		{Key: "user:1", TokenCost: 1},
+		{Key: "tenant:acme", TokenCost: 1},
+		{Key: "ip:10.0.0.1", TokenCost: 1},
+	})
+
+	// Assert
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	assert.Len(t, results, 3)
+	assert.True(t, results[0].Allowed)
+	assert.Equal(t, 9, results[0].Remaining)
+	assert.True(t, results[1].Allowed)
+	assert.Equal(t, 99, results[1].Remaining)
+	assert.False(t, results[2].Allowed, "Each descriptor should be decided by its own policy")
+	assert.Equal(t, 12*time.Second, results[2].RetryAfter)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokensBatch_NoScriptFallback(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+
+	// Mock the script cache being empty for the second descriptor, which should be resent with EVAL
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{user:1}"}, defaultBucketSize, defaultRefillRate, 1).
+		SetVal([]interface{}{int64(1), int64(9), int64(0), int64(1_000)})
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{user:2}"}, defaultBucketSize, defaultRefillRate, 2).
+		SetErr(redisError("NOSCRIPT No matching script. Please use EVAL."))
+	mock.ExpectEval(checkAndConsumeLua, []string{"rl:bucket:{user:2}"}, defaultBucketSize, defaultRefillRate, 2).
+		SetVal([]interface{}{int64(1), int64(8), int64(0), int64(2_000)})
+
+	// Act
+	results, errs := rateLimiter.CheckAndConsumeTokensBatch(ctx, []Descriptor{
+		{Key: "user:1", TokenCost: 1},
+		{Key: "user:2", TokenCost: 2},
+	})
+
+	// Assert
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, 9, results[0].Remaining)
+	assert.True(t, results[1].Allowed, "Request should be allowed after reloading the script")
+	assert.Equal(t, 8, results[1].Remaining)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokensBatch_ScriptError(t *testing.T) {
+	// Arrange
+	client, mock := redismock.NewClientMock()
+	rateLimiter := NewRateLimiter(client, nil)
+	ctx := context.Background()
+
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{user:1}"}, defaultBucketSize, defaultRefillRate, 1).
+		SetVal([]interface{}{int64(1), int64(9), int64(0), int64(1_000)})
+	mock.ExpectEvalSha(checkAndConsumeScript.Hash(), []string{"rl:bucket:{user:2}"}, defaultBucketSize, defaultRefillRate, 1).
+		SetErr(errors.New("redis connection error"))
+
+	// Act
+	results, errs := rateLimiter.CheckAndConsumeTokensBatch(ctx, []Descriptor{
+		{Key: "user:1", TokenCost: 1},
+		{Key: "user:2", TokenCost: 1},
+	})
+
+	// Assert
+	var backendErr *BackendError
+	assert.NoError(t, errs[0])
+	require.ErrorAs(t, errs[1], &backendErr)
+	assert.Equal(t, "user:2", backendErr.Key)
+	assert.True(t, results[0].Allowed, "A failure for one descriptor should not affect the others")
+	assert.Equal(t, Result{}, results[1])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckAndConsumeTokens_NonHashBucket(t *testing.T) {
+	// Arrange
+	rateLimiter, mr, _ := newRedisLimiter(t)
+	ctx := context.Background()
+
+	// A plain token count that never expires, as older versions stored buckets
+	require.NoError(t, mr.Set("rl:bucket:{user:1}", "2"))
+
+	// Act
+	result, err := rateLimiter.CheckAndConsumeTokens(ctx, "user:1", 1)
+	mr.Del("rl:bucket:{user:1}")
+	require.NoError(t, mr.Set("rl:bucket:{user:1}", "2"))
+	all, allErr := rateLimiter.CheckAndConsumeTokensAllOrNothing(ctx, []Descriptor{{Key: "user:1", TokenCost: 1}})
+
+	// Assert
+	require.NoError(t, err, "A value that is not a hash should not cause WRONGTYPE errors")
+	assert.Equal(t, Result{Allowed: true, Remaining: 9, Limit: defaultBucketSize, ResetAfter: time.Second}, result,
+		"A value that is not a hash should be treated as a missing bucket")
+	require.NoError(t, allErr)
+	assert.True(t, all[0].Allowed)
+	assert.Equal(t, 9, all[0].Remaining)
+	assert.Equal(t, time.Second, mr.TTL("rl:bucket:{user:1}"), "The bucket should now expire once it refills")
+}
+
+func TestBucketCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		ours   int
+		others int
+		want   int64
+		delta  float64
+	}{
+		{"empty database", 0, 0, 0, 0},
+		{"small database is scanned", 3, 2, 3, 0},
+		{"large database is sampled", 1500, 500, 1500, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			rateLimiter, mr, _ := newRedisLimiter(t)
+			mr.Seed(1)
+			for i := range tt.ours {
+				mr.Set(bucketKey("user:"+strconv.Itoa(i)), "1")
+			}
+			for i := range tt.others {
+				mr.Set("session:"+strconv.Itoa(i), "1")
+			}
+
+			// Act
+			count, err := rateLimiter.BucketCount(context.Background())
+
+			// Assert
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, count, tt.delta, "Only the rate limiter's keys should be counted")
+		})
+	}
+}
+
+func TestBucketCount_BackendError(t *testing.T) {
+	// Arrange
+	rateLimiter, mr, _ := newRedisLimiter(t)
+	mr.Close()
+
+	// Act
+	_, err := rateLimiter.BucketCount(context.Background())
+
+	// Assert
+	assert.ErrorAs(t, err, new(*BackendError))
+}
+
+func TestBucketKey_HashTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		suffix []string
+		want   string
+	}{
+		{"plain key is tagged", "user:1", nil, "rl:bucket:{user:1}"},
+		{"suffix precedes the key", "user:1", []string{"log"}, "rl:log:{user:1}"},
+		{"existing tag is kept", "{tenant:1}:user:2", nil, "rl:bucket:#{tenant:1}:user:2"},
+		{"existing tag is kept with suffix", "{tenant:1}:user:2", []string{"tat"}, "rl:tat:#{tenant:1}:user:2"},
+		{"fixed window", "user:1", []string{"window", "1709316000000"}, "rl:window:1709316000000:{user:1}"},
+		{"empty braces are not a tag", "user:{}", nil, "rl:bucket:{user:{}}"},
+		{"unclosed brace is not a tag", "user:{1", nil, "rl:bucket:{user:{1}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, bucketKey(tt.key, tt.suffix...))
+		})
+	}
+}
+
+func TestBucketKey_DoesNotCollide(t *testing.T) {
+	// Each pair of user keys and state kinds used to share a Redis key
+	tests := []struct {
+		name        string
+		key         string
+		suffix      []string
+		otherKey    string
+		otherSuffix []string
+	}{
+		{"tagged key ending in a suffix", "{t}:leases", nil, "{t}", []string{"leases"}},
+		{"tagged key ending in a window", "{t}:1709316000000", nil, "{t}", []string{"window", "1709316000000"}},
+		{"tagged key and wrapped key", "{t}:x}", nil, "t}:x", nil},
+		{"tagged key and its tag", "{a}", nil, "a", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NotEqual(t, bucketKey(tt.key, tt.suffix...), bucketKey(tt.otherKey, tt.otherSuffix...))
+		})
+	}
+}
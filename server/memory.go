@@ -0,0 +1,690 @@
+package server
+
+import (
+	"context"
+	"hash/maphash"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMemoryShards is the number of shards used when MemoryOptions.Shards is not set.
+	defaultMemoryShards = 64
+
+	// defaultEvictInterval is how often idle state is evicted when MemoryOptions.EvictInterval is not set.
+	defaultEvictInterval = time.Minute
+)
+
+// MemoryOptions configures a MemoryBackend.
+type MemoryOptions struct {
+	// Shards is the number of independently locked maps keys are spread across. Defaults to 64.
+	Shards int
+
+	// EvictInterval is how often state that has returned to its initial value, such as a full token bucket, is
+	// evicted. Defaults to one minute.
+	EvictInterval time.Duration
+
+	// IdleTimeout also evicts state that has not been used for this long, even if it has not returned to its
+	// initial value. This bounds memory for buckets that never refill, at the cost of forgetting their state.
+	// Zero disables it.
+	IdleTimeout time.Duration
+}
+
+// MemoryBackend keeps limiter state in process memory, for running a single rate limiter instance without Redis.
+// Keys are spread across shards, each protected by its own mutex, so checks for different keys rarely contend.
+// Limits are only enforced per process: instances sharing traffic do not share state.
+type MemoryBackend struct {
+	shards      []memoryShard
+	seed        maphash.Seed
+	idleTimeout time.Duration
+	now         func() time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// memoryEntry is the state stored under a single key, laid out like the Redis backend's keys.
+type memoryEntry struct {
+	state any
+
+	// expiresAt is when the state returns to its initial value and can be dropped. Zero if it never does.
+	expiresAt time.Time
+	lastUsed  time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// NewMemoryBackend creates a Backend that stores limiter state in process memory and starts evicting idle state
+// in the background. Call Close to stop eviction.
+func NewMemoryBackend(opts MemoryOptions) *MemoryBackend {
+	if opts.Shards <= 0 {
+		opts.Shards = defaultMemoryShards
+	}
+	if opts.EvictInterval <= 0 {
+		opts.EvictInterval = defaultEvictInterval
+	}
+
+	b := &MemoryBackend{
+		shards:      make([]memoryShard, opts.Shards),
+		seed:        maphash.MakeSeed(),
+		idleTimeout: opts.IdleTimeout,
+		now:         time.Now,
+		done:        make(chan struct{}),
+	}
+	for i := range b.shards {
+		b.shards[i].entries = make(map[string]*memoryEntry)
+	}
+
+	go b.evictEvery(opts.EvictInterval)
+	return b
+}
+
+// Close stops evicting idle state. The backend remains usable.
+func (b *MemoryBackend) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
+
+func (b *MemoryBackend) evictEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.evict()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// evict drops every entry that has expired or been idle for longer than the idle timeout, and returns the number
+// of entries dropped.
+func (b *MemoryBackend) evict() int {
+	now := b.now()
+	evicted := 0
+	for i := range b.shards {
+		s := &b.shards[i]
+		s.mu.Lock()
+		for key, e := range s.entries {
+			if e.expired(now) || (b.idleTimeout > 0 && now.Sub(e.lastUsed) >= b.idleTimeout) {
+				delete(s.entries, key)
+				evicted++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return evicted
+}
+
+// BucketCount returns the number of entries that have not expired, including any not yet evicted.
+func (b *MemoryBackend) BucketCount(ctx context.Context) (int64, error) {
+	now := b.now()
+	var count int64
+	for i := range b.shards {
+		s := &b.shards[i]
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if !e.expired(now) {
+				count++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return count, nil
+}
+
+func (b *MemoryBackend) shardIndex(key string) int {
+	return int(maphash.String(b.seed, key) % uint64(len(b.shards)))
+}
+
+// lock locks the shards holding keys, in index order so concurrent callers cannot deadlock, and returns a
+// function unlocking them.
+func (b *MemoryBackend) lock(keys ...string) func() {
+	indexes := make([]int, len(keys))
+	for i, key := range keys {
+		indexes[i] = b.shardIndex(key)
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	for _, i := range indexes {
+		b.shards[i].mu.Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			b.shards[i].mu.Unlock()
+		}
+	}
+}
+
+// entry returns the entry stored at key, replacing it with an empty one if it is missing or has expired. New
+// entries expire immediately unless the caller stores state in them. The key's shard must be locked.
+func (b *MemoryBackend) entry(key string, now time.Time) *memoryEntry {
+	s := &b.shards[b.shardIndex(key)]
+	e, ok := s.entries[key]
+	if !ok || e.expired(now) {
+		e = &memoryEntry{expiresAt: now}
+		s.entries[key] = e
+	}
+	e.lastUsed = now
+	return e
+}
+
+// Check applies the check's algorithm to its key's state.
+func (b *MemoryBackend) Check(ctx context.Context, check Check) (Result, error) {
+	now := b.now()
+	key := memoryKey(check, now)
+	defer b.lock(key)()
+
+	e := b.entry(key, now)
+	switch check.Policy.Algorithm {
+	case SlidingWindowLog:
+		return checkSlidingWindowLogEntry(e, check, now), nil
+	case SlidingWindowCounter:
+		return checkSlidingWindowCounterEntry(e, check, now), nil
+	case GCRA:
+		return checkGCRAEntry(e, check, now), nil
+	case FixedWindow:
+		return checkFixedWindowEntry(e, check, now), nil
+	default:
+		return checkTokenBucketEntry(e, check, now), nil
+	}
+}
+
+// memoryKey returns the key holding the state for check, matching the key the Redis backend would use.
+func memoryKey(check Check, now time.Time) string {
+	switch check.Policy.Algorithm {
+	case SlidingWindowLog:
+		return bucketKey(check.Key, "log")
+	case SlidingWindowCounter:
+		return bucketKey(check.Key, "counter")
+	case GCRA:
+		return bucketKey(check.Key, "tat")
+	case FixedWindow:
+		return fixedWindowKey(check.Key, check.Policy, now)
+	default:
+		return bucketKey(check.Key)
+	}
+}
+
+// CheckBatch applies each check in turn. It never fails.
+func (b *MemoryBackend) CheckBatch(ctx context.Context, checks []Check) ([]Result, []error) {
+	results := make([]Result, len(checks))
+	for i, check := range checks {
+		results[i], _ = b.Check(ctx, check)
+	}
+	return results, make([]error, len(checks))
+}
+
+// CheckAllOrNothing locks every check's shard and consumes from the token buckets only if all of them have
+// enough tokens. A key listed more than once must cover the sum of its costs.
+func (b *MemoryBackend) CheckAllOrNothing(ctx context.Context, checks []Check) ([]Result, error) {
+	now := b.now()
+	keys := make([]string, len(checks))
+	for i, c := range checks {
+		keys[i] = bucketKey(c.Key)
+	}
+	defer b.lock(keys...)()
+
+	// Work on copies so nothing is written unless every check is allowed
+	buckets := make(map[string]*tokenBucketState, len(checks))
+	available := make(map[string]float64, len(checks))
+	allowed := true
+	for i, c := range checks {
+		bucket, ok := buckets[keys[i]]
+		if !ok {
+			state := loadTokenBucket(b.entry(keys[i], now), c.Policy, now)
+			bucket = &state
+			buckets[keys[i]] = bucket
+			available[keys[i]] = bucket.tokens
+		}
+
+		if cost := max(0, float64(c.TokenCost)); bucket.tokens >= cost {
+			bucket.tokens -= cost
+		} else {
+			allowed = false
+		}
+	}
+
+	if allowed {
+		for i, c := range checks {
+			storeTokenBucket(b.entry(keys[i], now), *buckets[keys[i]], c.Policy, now)
+		}
+	}
+
+	results := make([]Result, len(checks))
+	needed := make(map[string]float64, len(checks))
+	for i, c := range checks {
+		tokens := available[keys[i]]
+		if allowed {
+			tokens = buckets[keys[i]].tokens
+		}
+
+		result := Result{
+			Allowed:    allowed,
+			Remaining:  int(math.Floor(tokens)),
+			Limit:      c.Policy.Capacity,
+			ResetAfter: tokenBucketResetAfter(tokens, c.Policy),
+		}
+		if !allowed {
+			needed[keys[i]] += max(0, float64(c.TokenCost))
+			result.RetryAfter = tokenBucketRetryAfter(tokens, needed[keys[i]], c.Policy)
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// Refill adds amount tokens to the key's token bucket.
+func (b *MemoryBackend) Refill(ctx context.Context, key string, policy Policy, amount int) (int, error) {
+	now := b.now()
+	stateKey := bucketKey(key)
+	defer b.lock(stateKey)()
+
+	e := b.entry(stateKey, now)
+	bucket := loadTokenBucket(e, policy, now)
+	bucket.tokens = math.Min(float64(policy.Capacity), bucket.tokens+float64(amount))
+	storeTokenBucket(e, bucket, policy, now)
+	return int(math.Floor(bucket.tokens)), nil
+}
+
+// AcquireLease drops expired leases and records leaseID if a slot is free.
+func (b *MemoryBackend) AcquireLease(ctx context.Context, key string, policy Policy, leaseID string) (LeaseState, error) {
+	now := b.now()
+	leaseKey := bucketKey(key, "leases")
+	defer b.lock(leaseKey)()
+
+	e := b.entry(leaseKey, now)
+	leases := activeLeases(e, now)
+	if policy.MaxConcurrent > 0 && len(leases) >= policy.MaxConcurrent {
+		return LeaseState{InUse: len(leases)}, nil
+	}
+
+	expiresAt := now.Add(policy.LeaseTTL)
+	leases[leaseID] = expiresAt
+	if expiresAt.After(e.expiresAt) {
+		e.expiresAt = expiresAt
+	}
+	return LeaseState{Acquired: true, InUse: len(leases), ExpiresAt: expiresAt}, nil
+}
+
+// ReleaseLease drops expired leases and removes leaseID.
+func (b *MemoryBackend) ReleaseLease(ctx context.Context, key string, leaseID string) (bool, int, error) {
+	now := b.now()
+	leaseKey := bucketKey(key, "leases")
+	defer b.lock(leaseKey)()
+
+	leases := activeLeases(b.entry(leaseKey, now), now)
+	_, released := leases[leaseID]
+	delete(leases, leaseID)
+	return released, len(leases), nil
+}
+
+// LeaseCount counts the unexpired leases held for key without touching its entry.
+func (b *MemoryBackend) LeaseCount(ctx context.Context, key string) (int, error) {
+	now := b.now()
+	leaseKey := bucketKey(key, "leases")
+	defer b.lock(leaseKey)()
+
+	e, ok := b.shards[b.shardIndex(leaseKey)].entries[leaseKey]
+	if !ok || e.expired(now) {
+		return 0, nil
+	}
+	leases, _ := e.state.(map[string]time.Time)
+	inUse := 0
+	for _, expiresAt := range leases {
+		if now.Before(expiresAt) {
+			inUse++
+		}
+	}
+	return inUse, nil
+}
+
+// SetTokens replaces the state of the policy's algorithm for key.
+func (b *MemoryBackend) SetTokens(ctx context.Context, key string, policy Policy, tokens int) error {
+	now := b.now()
+	stateKey := memoryKey(Check{Key: key, Policy: policy}, now)
+	defer b.lock(stateKey)()
+
+	e := b.entry(stateKey, now)
+	switch policy.Algorithm {
+	case SlidingWindowLog:
+		setSlidingWindowLogEntry(e, policy, tokens, now)
+	case SlidingWindowCounter:
+		setSlidingWindowCounterEntry(e, policy, tokens, now)
+	case GCRA:
+		setGCRAEntry(e, policy, tokens, now)
+	case FixedWindow:
+		setFixedWindowEntry(e, key, policy, tokens, now)
+	default:
+		storeTokenBucket(e, tokenBucketState{tokens: float64(tokens), ts: now}, policy, now)
+	}
+	return nil
+}
+
+// Delete removes the entries holding state for key.
+func (b *MemoryBackend) Delete(ctx context.Context, key string, policy Policy) (bool, error) {
+	now := b.now()
+	keys := stateKeys(key, policy, now)
+	defer b.lock(keys...)()
+
+	deleted := false
+	for _, k := range keys {
+		s := &b.shards[b.shardIndex(k)]
+		if e, ok := s.entries[k]; ok {
+			deleted = deleted || !e.expired(now)
+			delete(s.entries, k)
+		}
+	}
+	return deleted, nil
+}
+
+// activeLeases returns the entry's leases, keyed by lease ID, after dropping expired ones.
+func activeLeases(e *memoryEntry, now time.Time) map[string]time.Time {
+	leases, ok := e.state.(map[string]time.Time)
+	if !ok {
+		leases = make(map[string]time.Time)
+		e.state = leases
+	}
+	for id, expiresAt := range leases {
+		if !now.Before(expiresAt) {
+			delete(leases, id)
+		}
+	}
+	return leases
+}
+
+// tokenBucketState is a token bucket as of ts.
+type tokenBucketState struct {
+	tokens float64
+	ts     time.Time
+}
+
+// loadTokenBucket returns the entry's token bucket with the tokens accrued since it was stored. Missing buckets
+// start full.
+func loadTokenBucket(e *memoryEntry, policy Policy, now time.Time) tokenBucketState {
+	capacity := float64(policy.Capacity)
+	bucket, ok := e.state.(tokenBucketState)
+	if !ok {
+		return tokenBucketState{tokens: capacity, ts: now}
+	}
+
+	elapsed := max(0, now.Sub(bucket.ts))
+	return tokenBucketState{tokens: math.Min(capacity, bucket.tokens+elapsed.Seconds()*policy.RefillRate), ts: now}
+}
+
+// storeTokenBucket stores bucket in the entry, which expires once the bucket has refilled.
+func storeTokenBucket(e *memoryEntry, bucket tokenBucketState, policy Policy, now time.Time) {
+	e.state = bucket
+	e.expiresAt = time.Time{}
+	if resetAfter := tokenBucketResetAfter(bucket.tokens, policy); resetAfter >= 0 {
+		e.expiresAt = now.Add(resetAfter)
+	}
+}
+
+// tokenBucketRetryAfter returns how long until a bucket holding tokens has cost tokens, or NeverAllowed if it never
+// will.
+func tokenBucketRetryAfter(tokens, cost float64, policy Policy) time.Duration {
+	switch {
+	case cost <= tokens:
+		return 0
+	case cost > float64(policy.Capacity) || policy.RefillRate <= 0:
+		return NeverAllowed
+	default:
+		return ceilMilliseconds((cost - tokens) / policy.RefillRate)
+	}
+}
+
+// tokenBucketResetAfter returns how long until a bucket holding tokens is full, or -1 if it never will be.
+func tokenBucketResetAfter(tokens float64, policy Policy) time.Duration {
+	switch {
+	case tokens >= float64(policy.Capacity):
+		return 0
+	case policy.RefillRate <= 0:
+		return -1
+	default:
+		return ceilMilliseconds((float64(policy.Capacity) - tokens) / policy.RefillRate)
+	}
+}
+
+// ceilMilliseconds converts seconds to a duration rounded up to the millisecond, matching the Lua scripts.
+func ceilMilliseconds(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds*1000)) * time.Millisecond
+}
+
+func checkTokenBucketEntry(e *memoryEntry, check Check, now time.Time) Result {
+	bucket := loadTokenBucket(e, check.Policy, now)
+	cost := float64(check.TokenCost)
+
+	result := Result{Limit: check.Policy.Capacity}
+	if cost <= 0 || bucket.tokens >= cost {
+		bucket.tokens -= max(0, cost)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = tokenBucketRetryAfter(bucket.tokens, cost, check.Policy)
+	}
+
+	storeTokenBucket(e, bucket, check.Policy, now)
+	result.Remaining = int(math.Floor(bucket.tokens))
+	result.ResetAfter = tokenBucketResetAfter(bucket.tokens, check.Policy)
+	return result
+}
+
+// checkGCRAEntry applies the generic cell rate algorithm to the theoretical arrival time stored in the entry.
+func checkGCRAEntry(e *memoryEntry, check Check, now time.Time) Result {
+	burst := check.Policy.Capacity
+	cost := max(0, check.TokenCost)
+	interval := time.Duration(float64(time.Second) / check.Policy.RefillRate)
+	tolerance := interval * time.Duration(burst)
+
+	tat, _ := e.state.(time.Time)
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTAT := tat.Add(interval * time.Duration(cost))
+	diff := now.Sub(newTAT.Add(-tolerance))
+	if diff < 0 {
+		retryAfter := -diff
+		if cost > burst {
+			retryAfter = NeverAllowed
+		}
+		remaining := int(now.Sub(tat.Add(-tolerance)) / interval)
+		return Result{Remaining: remaining, Limit: burst, RetryAfter: retryAfter, ResetAfter: tat.Sub(now)}
+	}
+
+	resetAfter := newTAT.Sub(now)
+	if cost > 0 && resetAfter > 0 {
+		e.state = newTAT
+		e.expiresAt = newTAT
+	}
+	return Result{Allowed: true, Remaining: int(diff / interval), Limit: burst, ResetAfter: resetAfter}
+}
+
+// checkSlidingWindowLogEntry keeps one timestamp per consumed token in the entry, oldest first.
+func checkSlidingWindowLogEntry(e *memoryEntry, check Check, now time.Time) Result {
+	limit, window, cost := check.Policy.Limit, check.Policy.Window, check.TokenCost
+
+	log, _ := e.state.([]time.Time)
+	cutoff := now.Add(-window)
+	trimmed := 0
+	for trimmed < len(log) && !log[trimmed].After(cutoff) {
+		trimmed++
+	}
+	// Compact in place, so a busy key reuses one array instead of reallocating as the log slides along it
+	log = log[:copy(log, log[trimmed:])]
+
+	result := Result{Limit: limit}
+	switch {
+	case cost <= 0:
+		result.Allowed = true
+	case cost > limit:
+		result.RetryAfter = NeverAllowed
+	case len(log)+cost <= limit:
+		for range cost {
+			log = append(log, now)
+		}
+		result.Allowed = true
+	default:
+		// The request fits once enough of the oldest tokens have left the window
+		result.RetryAfter = log[len(log)+cost-limit-1].Add(window).Sub(now)
+	}
+
+	e.state = log
+	e.expiresAt = now
+	if len(log) > 0 {
+		e.expiresAt = log[len(log)-1].Add(window)
+		result.ResetAfter = e.expiresAt.Sub(now)
+	}
+	result.Remaining = limit - len(log)
+	return result
+}
+
+// slidingWindowCounterState holds the counts of the window starting at start and the window before it.
+type slidingWindowCounterState struct {
+	start     int64
+	cur, prev int
+}
+
+// checkSlidingWindowCounterEntry weights the previous window's count by how much of it still overlaps the
+// rolling window, using millisecond windows like the Lua script.
+func checkSlidingWindowCounterEntry(e *memoryEntry, check Check, now time.Time) Result {
+	limit, cost := check.Policy.Limit, check.TokenCost
+	window := check.Policy.Window.Milliseconds()
+	nowMs := now.UnixMilli()
+	current := nowMs - nowMs%window
+
+	var cur, prev int
+	if state, ok := e.state.(slidingWindowCounterState); ok {
+		switch state.start {
+		case current:
+			cur, prev = state.cur, state.prev
+		case current - window:
+			prev = state.cur
+		}
+	}
+
+	elapsed := nowMs - current
+	estimated := float64(prev)*float64(window-elapsed)/float64(window) + float64(cur)
+
+	result := Result{Limit: limit}
+	var retryAfterMs int64
+	switch {
+	case cost <= 0:
+		result.Allowed = true
+	case estimated+float64(cost) <= float64(limit):
+		estimated += float64(cost)
+		cur += cost
+		result.Allowed = true
+	case cost > limit:
+		retryAfterMs = -1
+	case cur+cost <= limit:
+		// The request fits once enough of the previous window has slid out
+		retryAfterMs = int64(math.Ceil(float64(window) - float64(window)*float64(limit-cur-cost)/float64(prev) - float64(elapsed)))
+	default:
+		// The request fits once the current window becomes the previous one and has partly slid out
+		retryAfterMs = window - elapsed + max(0, int64(math.Ceil(float64(window)-float64(window)*float64(limit-cost)/float64(cur))))
+	}
+
+	// The estimate drops to zero once every counted window has slid out
+	var resetAfter int64
+	if cur > 0 {
+		resetAfter = 2*window - elapsed
+	} else if prev > 0 {
+		resetAfter = window - elapsed
+	}
+
+	e.state = slidingWindowCounterState{start: current, cur: cur, prev: prev}
+	e.expiresAt = time.UnixMilli(nowMs + resetAfter)
+
+	result.Remaining = max(0, int(math.Floor(float64(limit)-estimated)))
+	result.RetryAfter = retryAfter(retryAfterMs, time.Millisecond)
+	result.ResetAfter = time.Duration(resetAfter) * time.Millisecond
+	return result
+}
+
+// checkFixedWindowEntry counts tokens consumed in the window the entry belongs to, which expires when the
+// window ends.
+func checkFixedWindowEntry(e *memoryEntry, check Check, now time.Time) Result {
+	limit, cost := check.Policy.Limit, check.TokenCost
+	end := windowStart(check.Key, check.Policy, now).Add(check.Policy.Window)
+	resetAfter := end.Sub(now)
+
+	count, _ := e.state.(int)
+	result := Result{Limit: limit, ResetAfter: resetAfter}
+	switch {
+	case cost <= 0:
+		result.Allowed = true
+	case count+cost > limit:
+		result.RetryAfter = resetAfter
+		if cost > limit {
+			result.RetryAfter = NeverAllowed
+		}
+	default:
+		count += cost
+		e.state = count
+		e.expiresAt = end
+		result.Allowed = true
+	}
+	result.Remaining = max(0, limit-count)
+	return result
+}
+
+// setGCRAEntry stores the theoretical arrival time at which exactly tokens fit in the burst now.
+func setGCRAEntry(e *memoryEntry, policy Policy, tokens int, now time.Time) {
+	interval := time.Duration(float64(time.Second) / policy.RefillRate)
+	e.state = nil
+	e.expiresAt = now
+	if tokens < policy.Capacity {
+		tat := now.Add(interval * time.Duration(policy.Capacity-tokens))
+		e.state = tat
+		e.expiresAt = tat
+	}
+}
+
+// setSlidingWindowLogEntry replaces the log with limit - tokens tokens consumed now.
+func setSlidingWindowLogEntry(e *memoryEntry, policy Policy, tokens int, now time.Time) {
+	log := make([]time.Time, policy.Limit-tokens)
+	for i := range log {
+		log[i] = now
+	}
+	e.state = log
+	e.expiresAt = now
+	if len(log) > 0 {
+		e.expiresAt = now.Add(policy.Window)
+	}
+}
+
+// setSlidingWindowCounterEntry replaces both counters with a current window count of limit - tokens.
+func setSlidingWindowCounterEntry(e *memoryEntry, policy Policy, tokens int, now time.Time) {
+	window := policy.Window.Milliseconds()
+	nowMs := now.UnixMilli()
+	current := nowMs - nowMs%window
+
+	count := policy.Limit - tokens
+	e.state = slidingWindowCounterState{start: current, cur: count}
+	e.expiresAt = now
+	if count > 0 {
+		e.expiresAt = time.UnixMilli(current + 2*window)
+	}
+}
+
+// setFixedWindowEntry sets the count of the window the entry belongs to to limit - tokens.
+func setFixedWindowEntry(e *memoryEntry, key string, policy Policy, tokens int, now time.Time) {
+	count := policy.Limit - tokens
+	e.state = count
+	e.expiresAt = now
+	if count > 0 {
+		e.expiresAt = windowStart(key, policy, now).Add(policy.Window)
+	}
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a settable clock for the memory backend.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newMemoryLimiter(t *testing.T, opts MemoryOptions, policies ...Policy) (*RateLimiter, *MemoryBackend, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)}
+	backend := NewMemoryBackend(opts)
+	backend.now = clock.Now
+	t.Cleanup(func() { backend.Close() })
+
+	set, err := NewPolicySet(DefaultPolicy(), policies)
+	require.NoError(t, err)
+	return NewRateLimiterWithBackend(backend, set), backend, clock
+}
+
+func TestMemoryBackend_TokenBucket(t *testing.T) {
+	// Arrange
+	rateLimiter, _, clock := newMemoryLimiter(t, MemoryOptions{})
+	ctx := context.Background()
+	key := "test:key"
+
+	// Act
+	first, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 10)
+	require.NoError(t, err)
+	denied, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 2)
+	require.NoError(t, err)
+	clock.Advance(2 * time.Second)
+	refilled, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 2)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, Result{Allowed: true, Remaining: 0, Limit: 10, ResetAfter: 10 * time.Second}, first)
+	assert.Equal(t, Result{Allowed: false, Remaining: 0, Limit: 10, RetryAfter: 2 * time.Second, ResetAfter: 10 * time.Second}, denied)
+	assert.Equal(t, Result{Allowed: true, Remaining: 0, Limit: 10, ResetAfter: 10 * time.Second}, refilled)
+}
+
+func TestMemoryBackend_TokenBucketCostOverCapacity(t *testing.T) {
+	// Arrange
+	rateLimiter, _, _ := newMemoryLimiter(t, MemoryOptions{})
+
+	// Act
+	result, err := rateLimiter.CheckAndConsumeTokens(context.Background(), "test:key", 11)
+	require.NoError(t, err)
+
+	// Assert
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 10, result.Remaining)
+	assert.Equal(t, NeverAllowed, result.RetryAfter, "A cost over the capacity can never be allowed")
+	assert.Zero(t, result.ResetAfter)
+}
+
+func TestMemoryBackend_Refill(t *testing.T) {
+	// Arrange
+	rateLimiter, _, _ := newMemoryLimiter(t, MemoryOptions{})
+	ctx := context.Background()
+	key := "test:key"
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 8)
+	require.NoError(t, err)
+
+	// Act
+	refilled, err := rateLimiter.RefillTokens(ctx, key, 5)
+	require.NoError(t, err)
+	capped, err := rateLimiter.RefillTokens(ctx, key, 5)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, 7, refilled)
+	assert.Equal(t, 10, capped, "Refills are capped at the capacity")
+}
+
+func TestMemoryBackend_GCRA(t *testing.T) {
+	// Arrange
+	rateLimiter, _, clock := newMemoryLimiter(t, MemoryOptions{},
+		Policy{Name: "api", Prefix: "api:", Algorithm: GCRA, Capacity: 2, RefillRate: 10},
+	)
+	ctx := context.Background()
+	key := "api:tenant:1"
+
+	// Act
+	first, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+	second, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+	denied, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+	clock.Advance(100 * time.Millisecond)
+	retried, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, Result{Allowed: true, Remaining: 1, Limit: 2, ResetAfter: 100 * time.Millisecond}, first)
+	assert.Equal(t, Result{Allowed: true, Remaining: 0, Limit: 2, ResetAfter: 200 * time.Millisecond}, second)
+	assert.Equal(t, Result{Allowed: false, Remaining: 0, Limit: 2, RetryAfter: 100 * time.Millisecond, ResetAfter: 200 * time.Millisecond}, denied)
+	assert.True(t, retried.Allowed, "The request should be allowed once the retry time has passed")
+}
+
+func TestMemoryBackend_SlidingWindowLog(t *testing.T) {
+	// Arrange
+	rateLimiter, _, clock := newMemoryLimiter(t, MemoryOptions{},
+		Policy{Name: "logins", Prefix: "login:", Algorithm: SlidingWindowLog, Limit: 3, Window: time.Minute},
+	)
+	ctx := context.Background()
+	key := "login:user:1"
+
+	// Act
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+	clock.Advance(20 * time.Second)
+	_, err = rateLimiter.CheckAndConsumeTokens(ctx, key, 2)
+	require.NoError(t, err)
+	denied, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+	clock.Advance(40 * time.Second)
+	slid, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, Result{Allowed: false, Remaining: 0, Limit: 3, RetryAfter: 40 * time.Second, ResetAfter: time.Minute}, denied)
+	assert.Equal(t, Result{Allowed: true, Remaining: 0, Limit: 3, ResetAfter: time.Minute}, slid, "The oldest token should have left the window")
+}
+
+func TestMemoryBackend_SlidingWindowLogReusesArray(t *testing.T) {
+	// Arrange
+	rateLimiter, backend, clock := newMemoryLimiter(t, MemoryOptions{},
+		Policy{Name: "logins", Prefix: "login:", Algorithm: SlidingWindowLog, Limit: 4, Window: 4 * time.Second},
+	)
+	ctx := context.Background()
+	key := "login:user:1"
+	stateKey := bucketKey(key, "log")
+	logOf := func() []time.Time {
+		log, _ := backend.shards[backend.shardIndex(stateKey)].entries[stateKey].state.([]time.Time)
+		return log
+	}
+	for range 4 {
+		_, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+		require.NoError(t, err)
+		clock.Advance(time.Second)
+	}
+	full := logOf()
+
+	// Act
+	for range 20 {
+		result, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+		require.NoError(t, err)
+		require.True(t, result.Allowed, "The oldest token should leave the window every second")
+		clock.Advance(time.Second)
+	}
+
+	// Assert
+	log := logOf()
+	assert.Len(t, log, 4)
+	assert.Same(t, &full[0], &log[0], "The log should slide within its array rather than reallocating")
+}
+
+func TestMemoryBackend_SlidingWindowCounter(t *testing.T) {
+	// Arrange
+	rateLimiter, _, clock := newMemoryLimiter(t, MemoryOptions{},
+		Policy{Name: "search", Prefix: "search:", Algorithm: SlidingWindowCounter, Limit: 10, Window: time.Minute},
+	)
+	ctx := context.Background()
+	key := "search:user:1"
+
+	// Act
+	filled, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 10)
+	require.NoError(t, err)
+	clock.Advance(time.Minute + 30*time.Second)
+	weighted, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 6)
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, filled.Allowed)
+	assert.Equal(t, 0, filled.Remaining)
+	assert.Equal(t, Result{Allowed: false, Remaining: 5, Limit: 10, RetryAfter: 6 * time.Second, ResetAfter: 30 * time.Second}, weighted,
+		"Half of the previous window's 10 tokens should still count")
+}
+
+func TestMemoryBackend_FixedWindow(t *testing.T) {
+	// Arrange
+	rateLimiter, _, clock := newMemoryLimiter(t, MemoryOptions{},
+		Policy{Name: "exports", Prefix: "export:", Algorithm: FixedWindow, Limit: 2, Window: 24 * time.Hour, CalendarAligned: true},
+	)
+	ctx := context.Background()
+	key := "export:tenant:1"
+
+	// Act
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 2)
+	require.NoError(t, err)
+	denied, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+	clock.Advance(6 * time.Hour)
+	nextWindow, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 1)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, Result{Allowed: false, Remaining: 0, Limit: 2, RetryAfter: 6 * time.Hour, ResetAfter: 6 * time.Hour}, denied)
+	assert.Equal(t, Result{Allowed: true, Remaining: 1, Limit: 2, ResetAfter: 24 * time.Hour}, nextWindow)
+}
+
+func TestMemoryBackend_AllOrNothing(t *testing.T) {
+	// Arrange
+	rateLimiter, _, _ := newMemoryLimiter(t, MemoryOptions{})
+	ctx := context.Background()
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, "b", 9)
+	require.NoError(t, err)
+
+	// Act
+	denied, err := rateLimiter.CheckAndConsumeTokensAllOrNothing(ctx, []Descriptor{{Key: "a", TokenCost: 2}, {Key: "b", TokenCost: 2}})
+	require.NoError(t, err)
+	allowed, err := rateLimiter.CheckAndConsumeTokensAllOrNothing(ctx, []Descriptor{{Key: "a", TokenCost: 2}, {Key: "a", TokenCost: 3}})
+	require.NoError(t, err)
+
+	// Assert
+	assert.False(t, denied[0].Allowed)
+	assert.Equal(t, 10, denied[0].Remaining, "A denial should leave every bucket untouched")
+	assert.Zero(t, denied[0].RetryAfter)
+	assert.Equal(t, time.Second, denied[1].RetryAfter)
+
+	assert.True(t, allowed[0].Allowed)
+	assert.Equal(t, 5, allowed[1].Remaining, "Repeated keys should consume the sum of their costs")
+}
+
+func TestMemoryBackend_Leases(t *testing.T) {
+	// Arrange
+	rateLimiter, _, clock := newMemoryLimiter(t, MemoryOptions{},
+		Policy{Name: "jobs", Prefix: "jobs:", Capacity: 10, MaxConcurrent: 2, LeaseTTL: 10 * time.Second},
+	)
+	ctx := context.Background()
+	key := "jobs:tenant:1"
+	start := clock.Now()
+
+	// Act
+	first, err := rateLimiter.AcquireLease(ctx, key)
+	require.NoError(t, err)
+	second, err := rateLimiter.AcquireLease(ctx, key)
+	require.NoError(t, err)
+	full, err := rateLimiter.AcquireLease(ctx, key)
+	require.NoError(t, err)
+	released, inUse, err := rateLimiter.ReleaseLease(ctx, key, first.ID)
+	require.NoError(t, err)
+	clock.Advance(10 * time.Second)
+	expired, err := rateLimiter.AcquireLease(ctx, key)
+	require.NoError(t, err)
+
+	// Assert
+	assert.True(t, first.Acquired)
+	assert.Equal(t, start.Add(10*time.Second), first.ExpiresAt)
+	assert.True(t, second.Acquired)
+	assert.Equal(t, Lease{InUse: 2, Limit: 2}, full)
+	assert.True(t, released)
+	assert.Equal(t, 1, inUse)
+	assert.True(t, expired.Acquired)
+	assert.Equal(t, 1, expired.InUse, "The second lease should have expired")
+}
+
+func TestMemoryBackend_Evict(t *testing.T) {
+	// Arrange
+	rateLimiter, backend, clock := newMemoryLimiter(t, MemoryOptions{Shards: 4, IdleTimeout: time.Hour},
+		Policy{Name: "static", Prefix: "static:", Capacity: 10, RefillRate: 0},
+	)
+	ctx := context.Background()
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, "refills", 5)
+	require.NoError(t, err)
+	_, err = rateLimiter.CheckAndConsumeTokens(ctx, "static:key", 5)
+	require.NoError(t, err)
+
+	// Act
+	clock.Advance(5 * time.Second)
+	refilled := backend.evict()
+	clock.Advance(time.Hour)
+	idle := backend.evict()
+
+	// Assert
+	assert.Equal(t, 1, refilled, "A full bucket should be evicted")
+	assert.Equal(t, 1, idle, "A bucket that never refills should be evicted once idle")
+}
+
+func TestMemoryBackend_BucketCount(t *testing.T) {
+	// Arrange
+	rateLimiter, _, clock := newMemoryLimiter(t, MemoryOptions{},
+		Policy{Name: "static", Prefix: "static:", Capacity: 10, RefillRate: 0},
+	)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "static:key"} {
+		_, err := rateLimiter.CheckAndConsumeTokens(ctx, key, 2)
+		require.NoError(t, err)
+	}
+
+	// Act
+	before, err := rateLimiter.BucketCount(ctx)
+	require.NoError(t, err)
+	clock.Advance(2 * time.Second)
+	after, err := rateLimiter.BucketCount(ctx)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, int64(3), before)
+	assert.Equal(t, int64(1), after, "Buckets that have refilled to full should not be counted")
+}
+
+func TestMemoryBackend_Concurrent(t *testing.T) {
+	// Arrange
+	rateLimiter, _, _ := newMemoryLimiter(t, MemoryOptions{},
+		Policy{Name: "static", Prefix: "static:", Capacity: 100, RefillRate: 0},
+	)
+	ctx := context.Background()
+
+	// Act
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				result, err := rateLimiter.CheckAndConsumeTokens(ctx, "static:key", 1)
+				if assert.NoError(t, err) && result.Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	assert.Equal(t, 100, allowed, "Concurrent callers must never spend the same tokens")
+}
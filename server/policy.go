@@ -0,0 +1,330 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Algorithm selects how a policy enforces its limit.
+type Algorithm string
+
+const (
+	// TokenBucket allows bursts up to Capacity and refills continuously at RefillRate. It is the default.
+	TokenBucket Algorithm = "token_bucket"
+
+	// GCRA (generic cell rate algorithm) enforces the same limit as TokenBucket, with Capacity as the burst and
+	// RefillRate as the rate, storing a single timestamp per key and reporting exact retry times.
+	GCRA Algorithm = "gcra"
+
+	// SlidingWindowLog allows at most Limit requests in any rolling Window by logging every request.
+	SlidingWindowLog Algorithm = "sliding_window_log"
+
+	// SlidingWindowCounter approximates SlidingWindowLog using two fixed-window counters per key.
+	SlidingWindowCounter Algorithm = "sliding_window_counter"
+
+	// FixedWindow allows at most Limit requests per fixed Window, e.g. a daily quota.
+	FixedWindow Algorithm = "fixed_window"
+)
+
+// FailureMode selects how requests for a policy's keys are answered when the backend fails.
+type FailureMode string
+
+const (
+	// FailError returns the backend error to the caller, which decides how to proceed. It is the default.
+	FailError FailureMode = "error"
+
+	// FailOpen allows every request while the backend is failing.
+	FailOpen FailureMode = "fail_open"
+
+	// FailClosed denies every request while the backend is failing.
+	FailClosed FailureMode = "fail_closed"
+
+	// FailLocal enforces the policy against a local in-memory fallback while the backend is failing, each instance
+	// enforcing its share of the limit. Consumption is replayed into the backend once it recovers.
+	FailLocal FailureMode = "local"
+)
+
+// Policy describes the limit applied to every key it matches.
+type Policy struct {
+	// Name identifies the policy in logs and metrics.
+	Name string `yaml:"name"`
+
+	// Exactly one of Key, Prefix or Glob selects the keys the policy applies to.
+	// Glob supports '*' (any run of characters) and '?' (any single character).
+	Key    string `yaml:"key"`
+	Prefix string `yaml:"prefix"`
+	Glob   string `yaml:"glob"`
+
+	// Algorithm enforcing the limit. Defaults to TokenBucket.
+	Algorithm Algorithm `yaml:"algorithm"`
+
+	// Capacity is the maximum number of tokens a bucket can hold. New buckets start full.
+	// Used by TokenBucket, and as the burst by GCRA.
+	Capacity int `yaml:"capacity"`
+
+	// RefillRate is the number of tokens per second added back to a bucket.
+	// Used by TokenBucket, and as the rate by GCRA.
+	RefillRate float64 `yaml:"refill_rate"`
+
+	// Limit is the number of tokens that may be consumed within Window.
+	// Used by SlidingWindowLog, SlidingWindowCounter and FixedWindow.
+	Limit int `yaml:"limit"`
+
+	// Window is the length of the window, e.g. "60s".
+	// Used by SlidingWindowLog, SlidingWindowCounter and FixedWindow.
+	Window time.Duration `yaml:"window"`
+
+	// CalendarAligned starts fixed windows at multiples of Window since the Unix epoch, so "1h" windows start at
+	// the top of each hour and "24h" windows at midnight UTC. Otherwise each key's windows are offset by a hash
+	// of the key so that all keys do not reset at the same instant.
+	// Used by FixedWindow.
+	CalendarAligned bool `yaml:"calendar_aligned"`
+
+	// MaxConcurrent is the number of leases that may be held for a key at once, independent of the algorithm
+	// limiting its request rate. Zero means leases are tracked but never refused.
+	MaxConcurrent int `yaml:"max_concurrent"`
+
+	// LeaseTTL is how long a lease is held before it expires if it is not released. Defaults to 30s.
+	LeaseTTL time.Duration `yaml:"lease_ttl"`
+
+	// FailureMode selects how requests are answered when the backend fails. Defaults to FailError.
+	FailureMode FailureMode `yaml:"failure_mode"`
+}
+
+// DefaultPolicy returns the policy applied to keys that no configured policy matches.
+func DefaultPolicy() Policy {
+	return Policy{
+		Name:        "default",
+		Algorithm:   TokenBucket,
+		Capacity:    defaultBucketSize,
+		RefillRate:  defaultRefillRate,
+		LeaseTTL:    defaultLeaseTTL,
+		FailureMode: FailError,
+	}
+}
+
+// limit returns the most tokens a key governed by p can have available at once.
+func (p Policy) limit() int {
+	switch p.Algorithm {
+	case TokenBucket, GCRA:
+		return p.Capacity
+	default:
+		return p.Limit
+	}
+}
+
+// perNode returns p with its rate and limits divided among nodes instances, rounding limits up so none drops to
+// zero.
+func (p Policy) perNode(nodes int) Policy {
+	if nodes <= 1 {
+		return p
+	}
+	p.Capacity = (p.Capacity + nodes - 1) / nodes
+	p.RefillRate /= float64(nodes)
+	p.Limit = (p.Limit + nodes - 1) / nodes
+	p.MaxConcurrent = (p.MaxConcurrent + nodes - 1) / nodes
+	return p
+}
+
+// normalize fills in defaults and checks that the parameters required by the policy's algorithm are set.
+func (p *Policy) normalize() error {
+	if p.Algorithm == "" {
+		p.Algorithm = TokenBucket
+	}
+	if p.LeaseTTL == 0 {
+		p.LeaseTTL = defaultLeaseTTL
+	}
+	if p.FailureMode == "" {
+		p.FailureMode = FailError
+	}
+	if p.MaxConcurrent < 0 {
+		return fmt.Errorf("policy %q: max_concurrent must not be negative, got %d", p.Name, p.MaxConcurrent)
+	}
+	if p.LeaseTTL < time.Millisecond {
+		return fmt.Errorf("policy %q: lease_ttl must be at least 1ms, got %s", p.Name, p.LeaseTTL)
+	}
+	switch p.FailureMode {
+	case FailError, FailOpen, FailClosed, FailLocal:
+	default:
+		return fmt.Errorf("policy %q: unknown failure_mode %q", p.Name, p.FailureMode)
+	}
+
+	switch p.Algorithm {
+	case TokenBucket:
+		if p.Capacity <= 0 {
+			return fmt.Errorf("policy %q: capacity must be positive, got %d", p.Name, p.Capacity)
+		}
+		if p.RefillRate < 0 {
+			return fmt.Errorf("policy %q: refill_rate must not be negative, got %g", p.Name, p.RefillRate)
+		}
+	case GCRA:
+		if p.Capacity <= 0 {
+			return fmt.Errorf("policy %q: capacity must be positive, got %d", p.Name, p.Capacity)
+		}
+		if p.RefillRate <= 0 {
+			return fmt.Errorf("policy %q: refill_rate must be positive, got %g", p.Name, p.RefillRate)
+		}
+	case SlidingWindowLog, SlidingWindowCounter, FixedWindow:
+		if p.Limit <= 0 {
+			return fmt.Errorf("policy %q: limit must be positive, got %d", p.Name, p.Limit)
+		}
+		if p.Window < time.Millisecond {
+			return fmt.Errorf("policy %q: window must be at least 1ms, got %s", p.Name, p.Window)
+		}
+	default:
+		return fmt.Errorf("policy %q: unknown algorithm %q", p.Name, p.Algorithm)
+	}
+	return nil
+}
+
+type globPolicy struct {
+	pattern *regexp.Regexp
+	policy  Policy
+}
+
+// PolicySet maps keys to the policy that governs them. It is immutable once built.
+//
+// Matching precedence is: exact key, then the longest matching prefix, then the first matching glob
+// in configuration order, and finally the default policy.
+type PolicySet struct {
+	defaultPolicy Policy
+	exact         map[string]Policy
+	prefixes      []Policy
+	globs         []globPolicy
+}
+
+// policyFile is the on-disk layout of a policy configuration file.
+type policyFile struct {
+	Default  *Policy  `yaml:"default"`
+	Policies []Policy `yaml:"policies"`
+}
+
+// DefaultPolicies returns a PolicySet that applies DefaultPolicy to every key.
+func DefaultPolicies() *PolicySet {
+	set, _ := NewPolicySet(DefaultPolicy(), nil)
+	return set
+}
+
+// NewPolicySet validates the given policies and builds a PolicySet from them.
+func NewPolicySet(defaultPolicy Policy, policies []Policy) (*PolicySet, error) {
+	if err := defaultPolicy.normalize(); err != nil {
+		return nil, err
+	}
+
+	set := &PolicySet{
+		defaultPolicy: defaultPolicy,
+		exact:         make(map[string]Policy),
+	}
+
+	for i, p := range policies {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("policy-%d", i)
+		}
+		if err := p.normalize(); err != nil {
+			return nil, err
+		}
+
+		matchers := 0
+		for _, m := range []string{p.Key, p.Prefix, p.Glob} {
+			if m != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return nil, fmt.Errorf("policy %q: exactly one of key, prefix or glob must be set", p.Name)
+		}
+
+		switch {
+		case p.Key != "":
+			if existing, ok := set.exact[p.Key]; ok {
+				return nil, fmt.Errorf("policy %q: key %q is already matched by policy %q", p.Name, p.Key, existing.Name)
+			}
+			set.exact[p.Key] = p
+		case p.Prefix != "":
+			set.prefixes = append(set.prefixes, p)
+		case p.Glob != "":
+			set.globs = append(set.globs, globPolicy{pattern: compileGlob(p.Glob), policy: p})
+		}
+	}
+
+	// Longest prefix wins, so check longer prefixes first
+	sort.SliceStable(set.prefixes, func(i, j int) bool {
+		return len(set.prefixes[i].Prefix) > len(set.prefixes[j].Prefix)
+	})
+
+	return set, nil
+}
+
+// ParsePolicies builds a PolicySet from a YAML or JSON document.
+func ParsePolicies(data []byte) (*PolicySet, error) {
+	var file policyFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policies: %w", err)
+	}
+
+	defaultPolicy := DefaultPolicy()
+	if file.Default != nil {
+		defaultPolicy = *file.Default
+		if defaultPolicy.Name == "" {
+			defaultPolicy.Name = "default"
+		}
+	}
+
+	return NewPolicySet(defaultPolicy, file.Policies)
+}
+
+// LoadPolicies reads a YAML or JSON policy file from disk.
+func LoadPolicies(path string) (*PolicySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %w", path, err)
+	}
+	return ParsePolicies(data)
+}
+
+// Match returns the policy that applies to key.
+func (s *PolicySet) Match(key string) Policy {
+	if p, ok := s.exact[key]; ok {
+		return p
+	}
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(key, p.Prefix) {
+			return p
+		}
+	}
+	for _, g := range s.globs {
+		if g.pattern.MatchString(key) {
+			return g.policy
+		}
+	}
+	return s.defaultPolicy
+}
+
+// Len returns the number of configured policies, not counting the default.
+func (s *PolicySet) Len() int {
+	return len(s.exact) + len(s.prefixes) + len(s.globs)
+}
+
+// compileGlob converts a glob pattern into an anchored regular expression.
+func compileGlob(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicySet_MatchPrecedence(t *testing.T) {
+	// Arrange
+	policies, err := NewPolicySet(DefaultPolicy(), []Policy{
+		{Name: "ips", Glob: "ip:*", Capacity: 5, RefillRate: 1},
+		{Name: "users", Prefix: "user:", Capacity: 20, RefillRate: 2},
+		{Name: "admins", Prefix: "user:admin:", Capacity: 200, RefillRate: 20},
+		{Name: "vip", Key: "user:admin:root", Capacity: 1000, RefillRate: 100},
+		{Name: "ip-any", Glob: "ip:10.0.0.?", Capacity: 50, RefillRate: 5},
+	})
+	require.NoError(t, err)
+
+	// Act & Assert
+	assert.Equal(t, "vip", policies.Match("user:admin:root").Name, "Exact key should win over prefixes")
+	assert.Equal(t, "admins", policies.Match("user:admin:bob").Name, "Longest prefix should win")
+	assert.Equal(t, "users", policies.Match("user:bob").Name)
+	assert.Equal(t, "ips", policies.Match("ip:10.0.0.1").Name, "First matching glob should win")
+	assert.Equal(t, "default", policies.Match("tenant:acme").Name, "Unmatched keys should use the default")
+	assert.Equal(t, 5, policies.Len())
+}
+
+func TestPolicySet_GlobMetacharacters(t *testing.T) {
+	// Arrange
+	policies, err := NewPolicySet(DefaultPolicy(), []Policy{
+		{Name: "api", Glob: "api/v1.?/*", Capacity: 5, RefillRate: 1},
+	})
+	require.NoError(t, err)
+
+	// Act & Assert
+	assert.Equal(t, "api", policies.Match("api/v1.2/users/42").Name)
+	assert.Equal(t, "default", policies.Match("api/v1x2/users").Name, "Dots should be matched literally")
+}
+
+func TestNewPolicySet_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []Policy
+	}{
+		{"no matcher", []Policy{{Name: "p", Capacity: 1}}},
+		{"two matchers", []Policy{{Name: "p", Key: "a", Prefix: "b", Capacity: 1}}},
+		{"zero capacity", []Policy{{Name: "p", Key: "a"}}},
+		{"negative refill rate", []Policy{{Name: "p", Key: "a", Capacity: 1, RefillRate: -1}}},
+		{"duplicate key", []Policy{{Name: "p", Key: "a", Capacity: 1}, {Name: "q", Key: "a", Capacity: 1}}},
+		{"gcra without rate", []Policy{{Name: "p", Key: "a", Algorithm: GCRA, Capacity: 1}}},
+		{"unknown algorithm", []Policy{{Name: "p", Key: "a", Algorithm: "leaky", Capacity: 1}}},
+		{"sliding window log without limit", []Policy{{Name: "p", Key: "a", Algorithm: SlidingWindowLog, Window: time.Second}}},
+		{"sliding window log without window", []Policy{{Name: "p", Key: "a", Algorithm: SlidingWindowLog, Limit: 1}}},
+		{"negative max concurrent", []Policy{{Name: "p", Key: "a", Capacity: 1, MaxConcurrent: -1}}},
+		{"negative lease ttl", []Policy{{Name: "p", Key: "a", Capacity: 1, LeaseTTL: -time.Second}}},
+		{"unknown failure mode", []Policy{{Name: "p", Key: "a", Capacity: 1, FailureMode: "retry"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPolicySet(DefaultPolicy(), tt.policies)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParsePolicies_YAML(t *testing.T) {
+	// Arrange
+	data := []byte(`
+default:
+  capacity: 30
+  refill_rate: 0.5
+policies:
+  - name: tenants
+    prefix: "tenant:"
+    capacity: 100
+    refill_rate: 10
+  - name: exports
+    key: exports
+    algorithm: sliding_window_log
+    limit: 60
+    window: 1m
+    max_concurrent: 2
+    lease_ttl: 10s
+    failure_mode: fail_open
+`)
+
+	// Act
+	policies, err := ParsePolicies(data)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, Policy{Name: "default", Algorithm: TokenBucket, Capacity: 30, RefillRate: 0.5, LeaseTTL: defaultLeaseTTL, FailureMode: FailError}, policies.Match("user:1"))
+	assert.Equal(t, Policy{Name: "tenants", Prefix: "tenant:", Algorithm: TokenBucket, Capacity: 100, RefillRate: 10, LeaseTTL: defaultLeaseTTL, FailureMode: FailError}, policies.Match("tenant:acme"))
+	assert.Equal(t, Policy{Name: "exports", Key: "exports", Algorithm: SlidingWindowLog, Limit: 60, Window: time.Minute, MaxConcurrent: 2, LeaseTTL: 10 * time.Second, FailureMode: FailOpen}, policies.Match("exports"))
+}
+
+func TestLoadPolicies_JSON(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "policies.json")
+	data := `{"policies": [{"key": "user:1", "capacity": 3, "refill_rate": 1}]}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	// Act
+	policies, err := LoadPolicies(path)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 3, policies.Match("user:1").Capacity)
+	assert.Equal(t, "policy-0", policies.Match("user:1").Name, "Unnamed policies should get a generated name")
+	assert.Equal(t, DefaultPolicy(), policies.Match("user:2"))
+}
+
+func TestLoadPolicies_MissingFile(t *testing.T) {
+	_, err := LoadPolicies(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestPolicy_PerNode(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		nodes  int
+		want   Policy
+	}{
+		{"single node is unchanged", Policy{Capacity: 10, RefillRate: 1}, 1, Policy{Capacity: 10, RefillRate: 1}},
+		{"token bucket", Policy{Capacity: 10, RefillRate: 4, MaxConcurrent: 5}, 4, Policy{Capacity: 3, RefillRate: 1, MaxConcurrent: 2}},
+		{"window limit", Policy{Algorithm: FixedWindow, Limit: 100, Window: time.Hour}, 3, Policy{Algorithm: FixedWindow, Limit: 34, Window: time.Hour}},
+		{"limits never drop to zero", Policy{Capacity: 1, RefillRate: 1}, 8, Policy{Capacity: 1, RefillRate: 0.125}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.perNode(tt.nodes))
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// pendingConsumption is the tokens consumed from the local fallback while the backend was failing, per key,
+// waiting to be replayed into the backend once it recovers.
+type pendingConsumption struct {
+	mu     sync.Mutex
+	tokens map[string]int
+
+	// dirty is set while tokens is non-empty, so successful checks can skip the lock.
+	dirty atomic.Bool
+
+	// replaying is set while a replay is running.
+	replaying atomic.Bool
+}
+
+// add records tokens consumed for key from the fallback.
+func (p *pendingConsumption) add(key string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokens == nil {
+		p.tokens = make(map[string]int)
+	}
+	p.tokens[key] += tokens
+	p.dirty.Store(true)
+}
+
+// take removes and returns every key's pending tokens.
+func (p *pendingConsumption) take() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tokens := p.tokens
+	p.tokens = nil
+	p.dirty.Store(false)
+	return tokens
+}
+
+// recordFallback remembers the tokens consumed by checks answered by the fallback, so they can be replayed.
+func (r *RateLimiter) recordFallback(checks []Check, results []Result) {
+	for i, c := range checks {
+		if results[i].Allowed {
+			r.pending.add(c.Key, c.TokenCost)
+		}
+	}
+}
+
+// maybeReplay starts replaying consumption from the fallback into the backend in the background, if there is any
+// and no replay is already running. It is called after the backend answers successfully, which means it has
+// recovered.
+func (r *RateLimiter) maybeReplay() {
+	if !r.pending.dirty.Load() || !r.pending.replaying.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer r.pending.replaying.Store(false)
+		r.Replay(context.Background())
+	}()
+}
+
+// Replay consumes the tokens that were consumed from the local fallback while the backend was failing from the
+// backend, so global limits account for them. Each key is charged against its current policy, at most down to
+// empty: consumption the backend no longer has room for is dropped rather than carried as debt. Keys the backend
+// fails for stay pending until the next replay. Returns the number of keys replayed.
+//
+// Replay runs automatically in the background after the backend recovers, so calling it is rarely needed.
+func (r *RateLimiter) Replay(ctx context.Context) int {
+	pending := r.pending.take()
+	if len(pending) == 0 {
+		return 0
+	}
+
+	policies := r.Policies()
+	checks := make([]Check, 0, len(pending))
+	for key, tokens := range pending {
+		checks = append(checks, Check{Key: key, Policy: policies.Match(key), TokenCost: tokens})
+	}
+	log.Printf("Replay: Replaying fallback consumption for %d keys", len(checks))
+
+	// A check consumes nothing if the key does not have room for all of it, so charge whatever room is left instead
+	results, errs := r.backend.CheckBatch(ctx, checks)
+	var partial []Check
+	replayed := 0
+	for i, c := range checks {
+		switch {
+		case errs[i] != nil:
+			log.Printf("Replay: Failed to replay %d tokens for key %s: %v", c.TokenCost, c.Key, errs[i])
+			r.pending.add(c.Key, c.TokenCost)
+		case !results[i].Allowed && results[i].Remaining > 0:
+			c.TokenCost = results[i].Remaining
+			partial = append(partial, c)
+		default:
+			replayed++
+		}
+	}
+
+	if len(partial) > 0 {
+		_, errs = r.backend.CheckBatch(ctx, partial)
+		for i, c := range partial {
+			if errs[i] != nil {
+				log.Printf("Replay: Failed to replay %d tokens for key %s: %v", c.TokenCost, c.Key, errs[i])
+				r.pending.add(c.Key, c.TokenCost)
+				continue
+			}
+			replayed++
+		}
+	}
+
+	log.Printf("Replay: Replayed fallback consumption for %d of %d keys", replayed, len(checks))
+	return replayed
+}
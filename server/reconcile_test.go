@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyBackend fails every call while down, and otherwise passes calls to the wrapped Backend.
+type flakyBackend struct {
+	Backend
+	down atomic.Bool
+}
+
+var errBackendDown = errors.New("connection refused")
+
+func (b *flakyBackend) Check(ctx context.Context, check Check) (Result, error) {
+	if b.down.Load() {
+		return Result{}, errBackendDown
+	}
+	return b.Backend.Check(ctx, check)
+}
+
+func (b *flakyBackend) CheckBatch(ctx context.Context, checks []Check) ([]Result, []error) {
+	if b.down.Load() {
+		return failingBackend{err: errBackendDown}.CheckBatch(ctx, checks)
+	}
+	return b.Backend.CheckBatch(ctx, checks)
+}
+
+func (b *flakyBackend) AcquireLease(ctx context.Context, key string, policy Policy, leaseID string) (LeaseState, error) {
+	if b.down.Load() {
+		return LeaseState{}, errBackendDown
+	}
+	return b.Backend.AcquireLease(ctx, key, policy, leaseID)
+}
+
+func (b *flakyBackend) ReleaseLease(ctx context.Context, key string, leaseID string) (bool, int, error) {
+	if b.down.Load() {
+		return false, 0, errBackendDown
+	}
+	return b.Backend.ReleaseLease(ctx, key, leaseID)
+}
+
+// newFlakyLimiter returns a RateLimiter whose primary backend can be taken down, with a local fallback shared by
+// nodes instances, and a 10 token bucket that never refills for keys starting with "local:".
+func newFlakyLimiter(t *testing.T, nodes int) (*RateLimiter, *flakyBackend) {
+	t.Helper()
+	primary := NewMemoryBackend(MemoryOptions{})
+	fallback := NewMemoryBackend(MemoryOptions{})
+	t.Cleanup(func() {
+		primary.Close()
+		fallback.Close()
+	})
+
+	policies, err := NewPolicySet(DefaultPolicy(), []Policy{
+		{Name: "local", Prefix: "local:", Capacity: 10, RefillRate: 0, FailureMode: FailLocal},
+	})
+	require.NoError(t, err)
+
+	backend := &flakyBackend{Backend: primary}
+	rateLimiter := NewRateLimiterWithBackend(backend, policies)
+	rateLimiter.SetFallback(fallback, nodes)
+	return rateLimiter, backend
+}
+
+// consume consumes one token at a time for key until it is denied, returning how many were allowed.
+func consume(t *testing.T, rateLimiter *RateLimiter, key string) int {
+	t.Helper()
+	for allowed := 0; ; allowed++ {
+		result, err := rateLimiter.CheckAndConsumeTokens(context.Background(), key, 1)
+		require.NoError(t, err)
+		if !result.Allowed {
+			return allowed
+		}
+	}
+}
+
+// remaining returns the tokens left for key in the primary backend.
+func remaining(t *testing.T, backend *flakyBackend, rateLimiter *RateLimiter, key string) int {
+	t.Helper()
+	result, err := backend.Backend.Check(context.Background(), Check{Key: key, Policy: rateLimiter.Policies().Match(key)})
+	require.NoError(t, err)
+	return result.Remaining
+}
+
+func TestFallback_DividesLimitsAmongNodes(t *testing.T) {
+	// Arrange
+	rateLimiter, backend := newFlakyLimiter(t, 4)
+	backend.down.Store(true)
+
+	// Act
+	allowed := consume(t, rateLimiter, "local:1")
+
+	// Assert
+	assert.Equal(t, 3, allowed, "Each of 4 nodes should enforce a quarter of the capacity, rounded up")
+}
+
+func TestReplay_ChargesBackend(t *testing.T) {
+	// Arrange
+	rateLimiter, backend := newFlakyLimiter(t, 2)
+	ctx := context.Background()
+	backend.down.Store(true)
+	for range 3 {
+		_, err := rateLimiter.CheckAndConsumeTokens(ctx, "local:1", 1)
+		require.NoError(t, err)
+	}
+	backend.down.Store(false)
+
+	// Act
+	replayed := rateLimiter.Replay(ctx)
+
+	// Assert
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, 7, remaining(t, backend, rateLimiter, "local:1"))
+	assert.Equal(t, 0, rateLimiter.Replay(ctx), "Consumption should only be replayed once")
+}
+
+func TestReplay_StopsAtEmpty(t *testing.T) {
+	// Arrange
+	rateLimiter, backend := newFlakyLimiter(t, 1)
+	ctx := context.Background()
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, "local:1", 8)
+	require.NoError(t, err)
+	backend.down.Store(true)
+	_, err = rateLimiter.CheckAndConsumeTokens(ctx, "local:1", 5)
+	require.NoError(t, err)
+	backend.down.Store(false)
+
+	// Act
+	replayed := rateLimiter.Replay(ctx)
+
+	// Assert
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, 0, remaining(t, backend, rateLimiter, "local:1"), "Replay should drain what is left without going into debt")
+}
+
+func TestReplay_KeepsConsumptionWhileBackendIsDown(t *testing.T) {
+	// Arrange
+	rateLimiter, backend := newFlakyLimiter(t, 1)
+	ctx := context.Background()
+	backend.down.Store(true)
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, "local:1", 4)
+	require.NoError(t, err)
+
+	// Act
+	failed := rateLimiter.Replay(ctx)
+	backend.down.Store(false)
+	replayed := rateLimiter.Replay(ctx)
+
+	// Assert
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, 6, remaining(t, backend, rateLimiter, "local:1"))
+}
+
+func TestReplay_RunsAfterRecovery(t *testing.T) {
+	// Arrange
+	rateLimiter, backend := newFlakyLimiter(t, 1)
+	ctx := context.Background()
+	backend.down.Store(true)
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, "local:1", 4)
+	require.NoError(t, err)
+	backend.down.Store(false)
+
+	// Act
+	_, err = rateLimiter.CheckAndConsumeTokens(ctx, "user:1", 1)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Eventually(t, func() bool {
+		return remaining(t, backend, rateLimiter, "local:1") == 6
+	}, time.Second, 10*time.Millisecond, "A successful check should replay consumption in the background")
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// bucketCountScanLimit is the most keys a Redis server can hold for BucketCount to count its buckets exactly.
+	bucketCountScanLimit = 1000
+
+	// bucketCountSamples is the number of random keys BucketCount samples on larger Redis servers.
+	bucketCountSamples = 100
+)
+
+// RedisBackend keeps limiter state in Redis, so every rate limiter instance sharing the Redis server enforces the
+// same limits. Each algorithm runs as a Lua script, which Redis executes atomically. Any go-redis client works,
+// including Redis Cluster and Sentinel failover clients; every script only touches keys in one cluster slot.
+type RedisBackend struct {
+	client redis.UniversalClient
+
+	// now returns the current time for algorithms that compute window boundaries outside of Redis.
+	now func() time.Time
+}
+
+// NewRedisBackend creates a Backend that stores limiter state in Redis. client may be a *redis.Client, a
+// *redis.ClusterClient or a failover client from redis.NewFailoverClient.
+func NewRedisBackend(client redis.UniversalClient) *RedisBackend {
+	return &RedisBackend{client: client, now: time.Now}
+}
+
+// scriptCall is a single run of an algorithm's Lua script and how to interpret its reply.
+type scriptCall struct {
+	script *redis.Script
+	keys   []string
+	args   []interface{}
+	result func(res []int64) Result
+}
+
+// run executes the script, using EVALSHA and falling back to EVAL (which reloads the script) on NOSCRIPT.
+func (c scriptCall) run(ctx context.Context, client redis.Scripter) (Result, error) {
+	res, err := c.script.Run(ctx, client, c.keys, c.args...).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return c.result(res), nil
+}
+
+// script returns the script call that applies check using its policy's algorithm.
+func (b *RedisBackend) script(check Check) scriptCall {
+	switch check.Policy.Algorithm {
+	case SlidingWindowLog:
+		return b.checkSlidingWindowLog(check.Key, check.Policy, check.TokenCost)
+	case SlidingWindowCounter:
+		return b.checkSlidingWindowCounter(check.Key, check.Policy, check.TokenCost)
+	case GCRA:
+		return b.checkGCRA(check.Key, check.Policy, check.TokenCost)
+	case FixedWindow:
+		return b.checkFixedWindow(check.Key, check.Policy, check.TokenCost)
+	default:
+		return b.checkTokenBucket(check.Key, check.Policy, check.TokenCost)
+	}
+}
+
+// Check runs the script for the check's algorithm.
+func (b *RedisBackend) Check(ctx context.Context, check Check) (Result, error) {
+	return b.script(check).run(ctx, b.client)
+}
+
+// CheckBatch runs the script for every check in a single pipeline.
+func (b *RedisBackend) CheckBatch(ctx context.Context, checks []Check) ([]Result, []error) {
+	calls := make([]scriptCall, len(checks))
+	for i, c := range checks {
+		calls[i] = b.script(c)
+	}
+
+	// Errors are reported per command, so the error returned by Exec is not needed
+	cmds := make([]*redis.Cmd, len(calls))
+	pipe := b.client.Pipeline()
+	for i, c := range calls {
+		cmds[i] = c.script.EvalSha(ctx, pipe, c.keys, c.args...)
+	}
+	_, _ = pipe.Exec(ctx)
+
+	// Scripts missing from the script cache are sent again in full, which also caches them
+	retry := b.client.Pipeline()
+	for i, c := range calls {
+		if redis.HasErrorPrefix(cmds[i].Err(), "NOSCRIPT") {
+			cmds[i] = c.script.Eval(ctx, retry, c.keys, c.args...)
+		}
+	}
+	if retry.Len() > 0 {
+		_, _ = retry.Exec(ctx)
+	}
+
+	results := make([]Result, len(calls))
+	errs := make([]error, len(calls))
+	for i, c := range calls {
+		res, err := cmds[i].Int64Slice()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		results[i] = c.result(res)
+	}
+	return results, errs
+}
+
+// Refill runs the refill script against the key's token bucket.
+func (b *RedisBackend) Refill(ctx context.Context, key string, policy Policy, amount int) (int, error) {
+	return refillScript.Run(ctx, b.client, []string{bucketKey(key)}, policy.Capacity, policy.RefillRate, amount).Int()
+}
+
+// SetTokens replaces the state of the policy's algorithm for key.
+func (b *RedisBackend) SetTokens(ctx context.Context, key string, policy Policy, tokens int) error {
+	switch policy.Algorithm {
+	case SlidingWindowLog:
+		return b.setSlidingWindowLog(ctx, key, policy, tokens)
+	case SlidingWindowCounter:
+		return b.setSlidingWindowCounter(ctx, key, policy, tokens)
+	case GCRA:
+		return b.setGCRA(ctx, key, policy, tokens)
+	case FixedWindow:
+		return b.setFixedWindow(ctx, key, policy, tokens)
+	default:
+		return b.setTokenBucket(ctx, key, policy, tokens)
+	}
+}
+
+// Delete removes every key holding state for key with a single DEL. They all share key's hash tag, so this works
+// with Redis Cluster too.
+func (b *RedisBackend) Delete(ctx context.Context, key string, policy Policy) (bool, error) {
+	n, err := b.client.Del(ctx, stateKeys(key, policy, b.now())...).Result()
+	return n > 0, err
+}
+
+// stateKeys returns every key that may hold state for key: one per algorithm, the lease set and, if policy uses
+// FixedWindow, the counter for the window containing now.
+func stateKeys(key string, policy Policy, now time.Time) []string {
+	keys := []string{
+		bucketKey(key),
+		bucketKey(key, "tat"),
+		bucketKey(key, "log"),
+		bucketKey(key, "counter"),
+		bucketKey(key, "leases"),
+	}
+	if policy.Algorithm == FixedWindow {
+		keys = append(keys, fixedWindowKey(key, policy, now))
+	}
+	return keys
+}
+
+// BucketCount returns the number of keys written by the rate limiter, summed over every master with Redis
+// Cluster. Keys the rate limiter writes expire once their state is no longer needed, except token buckets that
+// never refill, so this is the number of live buckets, windows and lease sets. Databases holding more than
+// bucketCountScanLimit keys are not scanned; the count is estimated from a sample of random keys instead.
+func (b *RedisBackend) BucketCount(ctx context.Context) (int64, error) {
+	cluster, ok := b.client.(*redis.ClusterClient)
+	if !ok {
+		return countBucketKeys(ctx, b.client)
+	}
+
+	var count atomic.Int64
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		n, err := countBucketKeys(ctx, master)
+		count.Add(n)
+		return err
+	})
+	return count.Load(), err
+}
+
+// countBucketKeys counts the keys in keyNamespace on a single Redis server: exactly by scanning them if the
+// database holds at most bucketCountScanLimit keys, or else by scaling the share of bucketCountSamples random keys
+// in the namespace to the database size.
+func countBucketKeys(ctx context.Context, client redis.Cmdable) (int64, error) {
+	size, err := client.DBSize(ctx).Result()
+	if err != nil || size == 0 {
+		return 0, err
+	}
+
+	if size <= bucketCountScanLimit {
+		var count int64
+		iter := client.Scan(ctx, 0, keyNamespace+"*", bucketCountScanLimit).Iterator()
+		for iter.Next(ctx) {
+			count++
+		}
+		return count, iter.Err()
+	}
+
+	// Keys can expire between the commands, which leaves RANDOMKEY with nothing to return
+	pipe := client.Pipeline()
+	samples := make([]*redis.StringCmd, bucketCountSamples)
+	for i := range samples {
+		samples[i] = pipe.RandomKey(ctx)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+	var matches int64
+	for _, cmd := range samples {
+		if strings.HasPrefix(cmd.Val(), keyNamespace) {
+			matches++
+		}
+	}
+	return size * matches / bucketCountSamples, nil
+}
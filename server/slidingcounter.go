@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindowCounterLua approximates a sliding window with two fixed-window counters stored as fields of a
+// single hash, keyed by window start. The previous window's count is weighted by how much of it still overlaps
+// the rolling window, which assumes its requests were evenly spread. It returns {allowed, remaining,
+// retry_after_ms, reset_after_ms}, with retry_after_ms set to -1 when the cost exceeds the limit and can never
+// be allowed.
+//
+// KEYS[1] - counter hash key
+// ARGV[1] - limit
+// ARGV[2] - window in milliseconds
+// ARGV[3] - token cost
+const slidingWindowCounterLua = `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local current = now - (now % window)
+local previous = current - window
+
+local cur, prev = 0, 0
+local counts = redis.call('HGETALL', KEYS[1])
+for i = 1, #counts, 2 do
+  local start = tonumber(counts[i])
+  if start == current then
+    cur = tonumber(counts[i + 1])
+  elseif start == previous then
+    prev = tonumber(counts[i + 1])
+  else
+    redis.call('HDEL', KEYS[1], counts[i])
+  end
+end
+
+local elapsed = now - current
+local estimated = prev * (window - elapsed) / window + cur
+
+local allowed = 0
+local retry_after = 0
+if cost <= 0 then
+  allowed = 1
+elseif estimated + cost <= limit then
+  redis.call('HINCRBY', KEYS[1], string.format('%d', current), cost)
+  redis.call('PEXPIRE', KEYS[1], window * 2)
+  estimated = estimated + cost
+  cur = cur + cost
+  allowed = 1
+elseif cost > limit then
+  retry_after = -1
+elseif cur + cost <= limit then
+  -- The request fits once enough of the previous window has slid out
+  retry_after = math.ceil(window - window * (limit - cur - cost) / prev - elapsed)
+else
+  -- The request fits once the current window becomes the previous one and has partly slid out
+  retry_after = window - elapsed + math.max(0, math.ceil(window - window * (limit - cost) / cur))
+end
+
+-- The estimate drops to zero once every counted window has slid out
+local reset_after = 0
+if cur > 0 then
+  reset_after = 2 * window - elapsed
+elseif prev > 0 then
+  reset_after = window - elapsed
+end
+
+return {allowed, math.max(0, math.floor(limit - estimated)), retry_after, reset_after}
+`
+
+// slidingWindowCounterSetLua replaces both counters with a current window count of limit - tokens.
+//
+// KEYS[1] - counter hash key
+// ARGV[1] - limit
+// ARGV[2] - window in milliseconds
+// ARGV[3] - number of tokens
+const slidingWindowCounterSetLua = `
+local count = tonumber(ARGV[1]) - tonumber(ARGV[3])
+local window = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local current = now - (now % window)
+
+redis.call('DEL', KEYS[1])
+if count > 0 then
+  redis.call('HSET', KEYS[1], string.format('%d', current), count)
+  redis.call('PEXPIRE', KEYS[1], window * 2)
+end
+return 1
+`
+
+var (
+	slidingWindowCounterScript    = redis.NewScript(slidingWindowCounterLua)
+	slidingWindowCounterSetScript = redis.NewScript(slidingWindowCounterSetLua)
+)
+
+// checkSlidingWindowCounter consumes tokens from a sliding-window-counter limit. It only keeps two counters
+// per key, trading the exactness of the sliding window log for constant memory.
+func (b *RedisBackend) checkSlidingWindowCounter(key string, policy Policy, tokenCost int) scriptCall {
+	return scriptCall{
+		script: slidingWindowCounterScript,
+		keys:   []string{bucketKey(key, "counter")},
+		args:   []interface{}{policy.Limit, policy.Window.Milliseconds(), tokenCost},
+		result: func(res []int64) Result {
+			return Result{
+				Allowed:    res[0] == 1,
+				Remaining:  int(res[1]),
+				Limit:      policy.Limit,
+				RetryAfter: retryAfter(res[2], time.Millisecond),
+				ResetAfter: time.Duration(res[3]) * time.Millisecond,
+			}
+		},
+	}
+}
+
+// setSlidingWindowCounter replaces the counters of a sliding-window-counter limit, counting the consumed tokens in
+// the current window.
+func (b *RedisBackend) setSlidingWindowCounter(ctx context.Context, key string, policy Policy, tokens int) error {
+	return slidingWindowCounterSetScript.Run(ctx, b.client, []string{bucketKey(key, "counter")}, policy.Limit, policy.Window.Milliseconds(), tokens).Err()
+}
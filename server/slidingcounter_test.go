@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckSlidingWindowCounter(t *testing.T) {
+	policy := Policy{Name: "anonymous", Glob: "ip:*", Algorithm: SlidingWindowCounter, Limit: 10, Window: time.Minute}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "previous window is weighted by its overlap",
+			steps: []step{
+				{cost: 4, want: Result{Allowed: true, Remaining: 6, Limit: 10, ResetAfter: 2 * time.Minute}, ttl: 2 * time.Minute},
+				{advance: 30 * time.Second, cost: 6, want: Result{Allowed: true, Remaining: 0, Limit: 10, ResetAfter: 90 * time.Second}, ttl: 2 * time.Minute},
+				// The request fits once this window is the previous one and a tenth of it has slid out
+				{cost: 1, want: Result{Remaining: 0, Limit: 10, RetryAfter: 36 * time.Second, ResetAfter: 90 * time.Second}, ttl: 2 * time.Minute},
+				{advance: 36 * time.Second, cost: 1, want: Result{Allowed: true, Remaining: 0, Limit: 10, ResetAfter: 114 * time.Second}, ttl: 2 * time.Minute},
+				// The previous window now only holds 1 token, weighted by 0.9
+				{advance: time.Minute, cost: 3, want: Result{Allowed: true, Remaining: 6, Limit: 10, ResetAfter: 114 * time.Second}, ttl: 2 * time.Minute},
+				{advance: 2 * time.Minute, cost: 0, want: Result{Allowed: true, Remaining: 10, Limit: 10}},
+			},
+		},
+		{
+			name: "denied until enough of the previous window slides out",
+			steps: []step{
+				{cost: 10, want: Result{Allowed: true, Remaining: 0, Limit: 10, ResetAfter: 2 * time.Minute}, ttl: 2 * time.Minute},
+				{advance: time.Minute, cost: 5, want: Result{Remaining: 0, Limit: 10, RetryAfter: 30 * time.Second, ResetAfter: time.Minute}, ttl: time.Minute},
+				{advance: 30 * time.Second, cost: 5, want: Result{Allowed: true, Remaining: 0, Limit: 10, ResetAfter: 90 * time.Second}, ttl: 2 * time.Minute},
+			},
+		},
+		{
+			name: "cost above the limit is never allowed",
+			steps: []step{
+				{cost: 11, want: Result{Remaining: 10, Limit: 10, RetryAfter: NeverAllowed}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rateLimiter, _, clock := newRedisLimiter(t, policy)
+			runSteps(t, rateLimiter, clock, "ip:10.0.0.1", tt.steps)
+		})
+	}
+}
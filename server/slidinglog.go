@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindowLogLua keeps a sorted set with one member per consumed token, scored by the time it was
+// consumed in microseconds. Members older than the window are trimmed before counting, so at most limit
+// tokens are ever consumed within any rolling window. It returns {allowed, remaining, retry_after_us,
+// reset_after_us}, with retry_after_us set to -1 when the cost exceeds the limit and can never be allowed.
+//
+// KEYS[1] - log key
+// ARGV[1] - limit
+// ARGV[2] - window in microseconds
+// ARGV[3] - token cost
+const slidingWindowLogLua = `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', string.format('%d', now - window))
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+local retry_after = 0
+if cost <= 0 then
+  allowed = 1
+elseif cost > limit then
+  retry_after = -1
+elseif count + cost <= limit then
+  -- The running count keeps members unique when two scripts observe the same microsecond
+  local score = string.format('%d', now)
+  for i = 1, cost do
+    redis.call('ZADD', KEYS[1], score, score .. ':' .. (count + i))
+  end
+  count = count + cost
+  allowed = 1
+  redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+else
+  -- The request fits once enough of the oldest tokens have left the window
+  local oldest = redis.call('ZRANGE', KEYS[1], count + cost - limit - 1, count + cost - limit - 1, 'WITHSCORES')
+  retry_after = tonumber(oldest[2]) + window - now
+end
+
+local reset_after = 0
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if #newest > 0 then
+  reset_after = tonumber(newest[2]) + window - now
+end
+
+return {allowed, limit - count, retry_after, reset_after}
+`
+
+// slidingWindowLogSetLua replaces the log with one recording limit - tokens tokens consumed now.
+//
+// KEYS[1] - log key
+// ARGV[1] - limit
+// ARGV[2] - window in microseconds
+// ARGV[3] - number of tokens
+const slidingWindowLogSetLua = `
+local count = tonumber(ARGV[1]) - tonumber(ARGV[3])
+local window = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+redis.call('DEL', KEYS[1])
+if count > 0 then
+  local score = string.format('%d', now)
+  for i = 1, count do
+    redis.call('ZADD', KEYS[1], score, score .. ':' .. i)
+  end
+  redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+end
+return 1
+`
+
+var (
+	slidingWindowLogScript    = redis.NewScript(slidingWindowLogLua)
+	slidingWindowLogSetScript = redis.NewScript(slidingWindowLogSetLua)
+)
+
+// checkSlidingWindowLog consumes tokens from a sliding-window-log limit. The log is stored separately from
+// token buckets so a key can switch algorithms without its state being misread.
+func (b *RedisBackend) checkSlidingWindowLog(key string, policy Policy, tokenCost int) scriptCall {
+	return scriptCall{
+		script: slidingWindowLogScript,
+		keys:   []string{bucketKey(key, "log")},
+		args:   []interface{}{policy.Limit, policy.Window.Microseconds(), tokenCost},
+		result: func(res []int64) Result {
+			return Result{
+				Allowed:    res[0] == 1,
+				Remaining:  int(res[1]),
+				Limit:      policy.Limit,
+				RetryAfter: retryAfter(res[2], time.Microsecond),
+				ResetAfter: time.Duration(res[3]) * time.Microsecond,
+			}
+		},
+	}
+}
+
+// setSlidingWindowLog replaces the log of a sliding-window-log limit so tokens can be consumed before the oldest
+// entries leave the window, which they all do one window from now.
+func (b *RedisBackend) setSlidingWindowLog(ctx context.Context, key string, policy Policy, tokens int) error {
+	return slidingWindowLogSetScript.Run(ctx, b.client, []string{bucketKey(key, "log")}, policy.Limit, policy.Window.Microseconds(), tokens).Err()
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSlidingWindowLog(t *testing.T) {
+	policy := Policy{Name: "strict", Prefix: "api:", Algorithm: SlidingWindowLog, Limit: 5, Window: time.Minute}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "tokens return as they leave the window",
+			steps: []step{
+				{cost: 2, want: Result{Allowed: true, Remaining: 3, Limit: 5, ResetAfter: time.Minute}, ttl: time.Minute},
+				{advance: 10 * time.Second, cost: 3, want: Result{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: time.Minute}, ttl: time.Minute},
+				// The request fits once the 2 tokens consumed first leave the window
+				{advance: 20 * time.Second, cost: 1, want: Result{Remaining: 0, Limit: 5, RetryAfter: 30 * time.Second, ResetAfter: 40 * time.Second}, ttl: 40 * time.Second},
+				{advance: 30 * time.Second, cost: 2, want: Result{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: time.Minute}, ttl: time.Minute},
+				{advance: time.Minute, cost: 0, want: Result{Allowed: true, Remaining: 5, Limit: 5}},
+			},
+		},
+		{
+			name: "denied until enough of the oldest tokens leave",
+			steps: []step{
+				{cost: 1, want: Result{Allowed: true, Remaining: 4, Limit: 5, ResetAfter: time.Minute}, ttl: time.Minute},
+				{advance: 15 * time.Second, cost: 4, want: Result{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: time.Minute}, ttl: time.Minute},
+				// Three tokens need the first one and two of the second batch to leave
+				{cost: 3, want: Result{Remaining: 0, Limit: 5, RetryAfter: time.Minute, ResetAfter: time.Minute}, ttl: time.Minute},
+				{advance: 45 * time.Second, cost: 1, want: Result{Allowed: true, Remaining: 0, Limit: 5, ResetAfter: time.Minute}, ttl: time.Minute},
+			},
+		},
+		{
+			name: "cost above the limit is never allowed",
+			steps: []step{
+				{cost: 6, want: Result{Remaining: 5, Limit: 5, RetryAfter: NeverAllowed}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rateLimiter, _, clock := newRedisLimiter(t, policy)
+			runSteps(t, rateLimiter, clock, "api:user:1", tt.steps)
+		})
+	}
+}
+
+func TestRefillTokens_SlidingWindowLogIsNoOp(t *testing.T) {
+	// Arrange
+	rateLimiter, _, _ := newRedisLimiter(t, Policy{Name: "strict", Prefix: "api:", Algorithm: SlidingWindowLog, Limit: 5, Window: time.Minute})
+	ctx := context.Background()
+	_, err := rateLimiter.CheckAndConsumeTokens(ctx, "api:user:1", 2)
+	require.NoError(t, err)
+
+	// Act
+	remaining, err := rateLimiter.RefillTokens(ctx, "api:user:1", 10)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, 3, remaining, "Refilling should only report the remaining count of the window")
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketFunctionsLua defines load_bucket and store_bucket.
+//
+// load_bucket returns the tokens in a bucket stored as a hash of {tokens, ts}, adding the tokens that accrued since
+// ts, capped at the bucket capacity. Missing buckets start full. Buckets are only ever written as hashes, but a
+// value of another type, e.g. set by hand, would fail every check with WRONGTYPE and might never expire, so it is
+// deleted and treated as a missing bucket.
+//
+// store_bucket saves a bucket with an expiry of the time it takes to refill to full. A full bucket behaves exactly
+// like a missing one, so it is deleted instead, and buckets that never refill are kept until they are written with
+// a positive rate.
+const tokenBucketFunctionsLua = `
+local function load_bucket(key, capacity, rate, now)
+  local kind = redis.call('TYPE', key)['ok']
+  if kind ~= 'hash' then
+    if kind ~= 'none' then
+      redis.call('DEL', key)
+    end
+    return capacity
+  end
+
+  local state = redis.call('HMGET', key, 'tokens', 'ts')
+  local tokens = tonumber(state[1])
+  local ts = tonumber(state[2])
+  if tokens == nil or ts == nil then
+    return capacity
+  end
+  return math.min(capacity, tokens + math.max(0, now - ts) * rate / 1000)
+end
+
+local function store_bucket(key, tokens, capacity, rate, now)
+  if tokens >= capacity then
+    redis.call('DEL', key)
+    return
+  end
+  redis.call('HSET', key, 'tokens', tokens, 'ts', now)
+  if rate > 0 then
+    redis.call('PEXPIRE', key, math.ceil((capacity - tokens) * 1000 / rate))
+  else
+    redis.call('PERSIST', key)
+  end
+end
+`
+
+// tokenBucketStateLua loads a bucket with load_bucket, lazily adding the tokens that accrued since it was stored.
+// Timestamps come from the Redis server clock so every rate limiter instance agrees on elapsed time.
+//
+// KEYS[1] - bucket key
+// ARGV[1] - bucket capacity
+// ARGV[2] - refill rate in tokens per second
+const tokenBucketStateLua = tokenBucketFunctionsLua + `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local tokens = load_bucket(KEYS[1], capacity, rate, now)
+`
+
+// checkAndConsumeLua refills the bucket and consumes tokens when enough are available.
+// It returns {allowed, remaining, retry_after_ms, reset_after_ms}, with -1 for durations that never elapse
+// because the cost exceeds the capacity or the bucket does not refill.
+//
+// ARGV[3] - token cost
+const checkAndConsumeLua = tokenBucketStateLua + `
+local cost = tonumber(ARGV[3])
+
+local allowed = 0
+local retry_after = 0
+if cost <= 0 then
+  allowed = 1
+elseif tokens >= cost then
+  tokens = tokens - cost
+  allowed = 1
+elseif cost > capacity or rate <= 0 then
+  retry_after = -1
+else
+  retry_after = math.ceil((cost - tokens) * 1000 / rate)
+end
+
+local reset_after = 0
+if tokens < capacity then
+  if rate > 0 then
+    reset_after = math.ceil((capacity - tokens) * 1000 / rate)
+  else
+    reset_after = -1
+  end
+end
+
+store_bucket(KEYS[1], tokens, capacity, rate, now)
+return {allowed, math.floor(tokens), retry_after, reset_after}
+`
+
+// refillLua refills the bucket and then adds a fixed number of tokens on top, capped at the
+// bucket capacity. It returns the new token count.
+//
+// ARGV[3] - number of tokens to add
+const refillLua = tokenBucketStateLua + `
+tokens = math.min(capacity, tokens + tonumber(ARGV[3]))
+
+store_bucket(KEYS[1], tokens, capacity, rate, now)
+return math.floor(tokens)
+`
+
+// setTokensLua replaces the bucket with one holding a fixed number of tokens as of now.
+//
+// KEYS[1] - bucket key
+// ARGV[1] - bucket capacity
+// ARGV[2] - refill rate in tokens per second
+// ARGV[3] - number of tokens
+const setTokensLua = tokenBucketFunctionsLua + `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+store_bucket(KEYS[1], tonumber(ARGV[3]), tonumber(ARGV[1]), tonumber(ARGV[2]), now)
+return 1
+`
+
+// Scripts cache the SHA of their source so they can be invoked with EVALSHA.
+var (
+	checkAndConsumeScript = redis.NewScript(checkAndConsumeLua)
+	refillScript          = redis.NewScript(refillLua)
+	setTokensScript       = redis.NewScript(setTokensLua)
+)
+
+// checkTokenBucket consumes tokens from a token bucket with the policy's capacity and refill rate.
+// Tokens accrue continuously, so buckets recover without anyone calling RefillTokens. The check and the
+// update run as a single Lua script so concurrent callers cannot both spend the same tokens.
+func (b *RedisBackend) checkTokenBucket(key string, policy Policy, tokenCost int) scriptCall {
+	return scriptCall{
+		script: checkAndConsumeScript,
+		keys:   []string{bucketKey(key)},
+		args:   []interface{}{policy.Capacity, policy.RefillRate, tokenCost},
+		result: func(res []int64) Result {
+			return Result{
+				Allowed:    res[0] == 1,
+				Remaining:  int(res[1]),
+				Limit:      policy.Capacity,
+				RetryAfter: retryAfter(res[2], time.Millisecond),
+				ResetAfter: time.Duration(res[3]) * time.Millisecond,
+			}
+		},
+	}
+}
+
+// setTokenBucket stores a token bucket holding tokens, timestamped with the Redis server clock like every other
+// write to the bucket.
+func (b *RedisBackend) setTokenBucket(ctx context.Context, key string, policy Policy, tokens int) error {
+	return setTokensScript.Run(ctx, b.client, []string{bucketKey(key)}, policy.Capacity, policy.RefillRate, tokens).Err()
+}
@@ -1,19 +1,29 @@
+//go:build integration
+
 package server
 
 import (
 	"context"
+	"encoding/json"
 	"math/rand"
+	"net/http"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	pb "github.com/carteralbrecht/rate-limiter/proto"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-const serverAddr = "localhost:50051"
+const (
+	serverAddr = "localhost:50051"
+	httpAddr   = "http://localhost:8080"
+)
 
 func setupTestClient(t *testing.T) pb.RateLimiterClient {
 	t.Helper()
@@ -68,6 +78,9 @@ func TestCheckLimit_ExceedsLimit(t *testing.T) {
 	// Assert
 	assert.NoError(t, err)
 	assert.False(t, resp.Allowed, "Request should be denied after bucket is empty")
+	assert.Equal(t, int32(10), resp.Limit)
+	assert.Positive(t, resp.RetryAfter.AsDuration(), "Denied requests should report when to retry")
+	assert.LessOrEqual(t, resp.RetryAfter.AsDuration(), time.Second)
 }
 
 func TestRefillBucket_IncreasesTokens(t *testing.T) {
@@ -76,7 +89,7 @@ func TestRefillBucket_IncreasesTokens(t *testing.T) {
 	userID := generateRandomUserID()
 
 	// Arrange
-	req := &pb.RefillRequest{Key: userID, LeakRate: 2, BucketSize: 10}
+	req := &pb.RefillRequest{Key: userID, LeakRate: 2}
 
 	// Act
 	resp, err := client.RefillBucket(ctx, req)
@@ -97,7 +110,7 @@ func TestCheckLimit_AllowedAfterRefill(t *testing.T) {
 	}
 
 	// Act - Refill the bucket
-	client.RefillBucket(ctx, &pb.RefillRequest{Key: userID, LeakRate: 5, BucketSize: 10})
+	client.RefillBucket(ctx, &pb.RefillRequest{Key: userID, LeakRate: 5})
 
 	// Act - Try a request again
 	resp, err := client.CheckLimit(ctx, &pb.CheckRequest{Key: userID, TokenCost: 1})
@@ -106,3 +119,210 @@ func TestCheckLimit_AllowedAfterRefill(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, resp.Allowed, "Request should be allowed after refill")
 }
+
+func TestCheckLimit_AllowedAfterWaiting(t *testing.T) {
+	client := setupTestClient(t)
+	ctx := context.Background()
+	userID := generateRandomUserID()
+
+	// Arrange - Empty the bucket
+	for i := 0; i < 10; i++ {
+		client.CheckLimit(ctx, &pb.CheckRequest{Key: userID, TokenCost: 1})
+	}
+
+	// Act - Wait for the bucket to refill on its own
+	time.Sleep(1100 * time.Millisecond)
+	resp, err := client.CheckLimit(ctx, &pb.CheckRequest{Key: userID, TokenCost: 1})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, resp.Allowed, "Request should be allowed once tokens have accrued")
+}
+
+func TestLease_AcquireAndRelease(t *testing.T) {
+	client := setupTestClient(t)
+	ctx := context.Background()
+	userID := generateRandomUserID()
+
+	// Act
+	lease, err := client.AcquireLease(ctx, &pb.AcquireLeaseRequest{Key: userID})
+	assert.NoError(t, err)
+	released, releaseErr := client.ReleaseLease(ctx, &pb.ReleaseLeaseRequest{Key: userID, LeaseId: lease.LeaseId})
+	releasedAgain, _ := client.ReleaseLease(ctx, &pb.ReleaseLeaseRequest{Key: userID, LeaseId: lease.LeaseId})
+
+	// Assert
+	assert.True(t, lease.Acquired, "Lease should be acquired")
+	assert.NotEmpty(t, lease.LeaseId)
+	assert.NoError(t, releaseErr)
+	assert.True(t, released.Released, "Lease should be released")
+	assert.False(t, releasedAgain.Released, "Lease should only be released once")
+}
+
+func TestCheckLimits_PerDescriptorResults(t *testing.T) {
+	client := setupTestClient(t)
+	ctx := context.Background()
+	drainedID := generateRandomUserID()
+	freshID := drainedID + "_fresh"
+
+	// Arrange - Drain one of the buckets
+	client.CheckLimit(ctx, &pb.CheckRequest{Key: drainedID, TokenCost: 10})
+
+	// Act
+	resp, err := client.CheckLimits(ctx, &pb.CheckLimitsRequest{
+		Descriptors: []*pb.CheckRequest{
+			{Key: freshID, TokenCost: 1},
+			{Key: drainedID, TokenCost: 1},
+		},
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.False(t, resp.Allowed, "Batch should be denied if any descriptor is denied")
+	assert.Len(t, resp.Results, 2)
+	assert.True(t, resp.Results[0].Allowed, "Fresh bucket should be allowed")
+	assert.False(t, resp.Results[1].Allowed, "Drained bucket should be denied")
+}
+
+func TestCheckLimits_AllOrNothingLeavesBucketsUntouched(t *testing.T) {
+	client := setupTestClient(t)
+	ctx := context.Background()
+
+	// Share a hash tag so both buckets live in the same slot when running against Redis Cluster
+	drainedID := "{" + generateRandomUserID() + "}"
+	freshID := drainedID + "_fresh"
+
+	// Arrange - Drain one of the buckets
+	client.CheckLimit(ctx, &pb.CheckRequest{Key: drainedID, TokenCost: 10})
+
+	// Act
+	resp, err := client.CheckLimits(ctx, &pb.CheckLimitsRequest{
+		Descriptors: []*pb.CheckRequest{
+			{Key: freshID, TokenCost: 1},
+			{Key: drainedID, TokenCost: 1},
+		},
+		AllOrNothing: true,
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.False(t, resp.Allowed, "Batch should be denied if any descriptor is denied")
+	assert.Equal(t, int32(10), resp.Results[0].Remaining, "Fresh bucket should not be debited")
+}
+
+func TestCheckLimits_KeysAcrossSlots(t *testing.T) {
+	client := setupTestClient(t)
+	ctx := context.Background()
+	userID := generateRandomUserID()
+
+	// Arrange - Enough keys to land on every node of a Redis Cluster
+	var descriptors []*pb.CheckRequest
+	for i := 0; i < 32; i++ {
+		descriptors = append(descriptors, &pb.CheckRequest{Key: userID + "_" + strconv.Itoa(i), TokenCost: 1})
+	}
+
+	// Act
+	resp, err := client.CheckLimits(ctx, &pb.CheckLimitsRequest{Descriptors: descriptors})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, resp.Allowed, "Every fresh bucket should be allowed")
+	for i, result := range resp.Results {
+		assert.Equal(t, int32(9), result.Remaining, "descriptor %d", i)
+	}
+}
+
+func TestCheckLimitStream_MatchesResponsesByRequestID(t *testing.T) {
+	client := setupTestClient(t)
+	ctx := context.Background()
+	userID := generateRandomUserID()
+
+	stream, err := client.CheckLimitStream(ctx)
+	assert.NoError(t, err)
+
+	// Act - Send enough checks to drain the bucket and then some
+	for i := 0; i < 12; i++ {
+		err := stream.Send(&pb.StreamCheckRequest{RequestId: uint64(i), Check: &pb.CheckRequest{Key: userID, TokenCost: 1}})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, stream.CloseSend())
+
+	responses := make(map[uint64]*pb.CheckResponse)
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		responses[resp.RequestId] = resp.Result
+	}
+
+	// Assert
+	assert.Len(t, responses, 12, "Every request should get exactly one response")
+	allowed := 0
+	for _, result := range responses {
+		if result.Allowed {
+			allowed++
+		}
+	}
+	assert.Equal(t, 10, allowed, "Only a full bucket's worth of checks should be allowed")
+}
+
+func TestShouldRateLimit_EnvoyDescriptors(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := rlsv3.NewRateLimitServiceClient(conn)
+
+	// Arrange - One descriptor that matches no policy, so it gets the default bucket of 10
+	req := &rlsv3.RateLimitRequest{
+		Domain: "integration",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{
+			{Entries: []*ratelimitv3.RateLimitDescriptor_Entry{{Key: "user", Value: generateRandomUserID()}}},
+		},
+		HitsAddend: 4,
+	}
+
+	// Act
+	first, err := client.ShouldRateLimit(ctx, req)
+	assert.NoError(t, err)
+	second, _ := client.ShouldRateLimit(ctx, req)
+	third, _ := client.ShouldRateLimit(ctx, req)
+
+	// Assert
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, first.OverallCode)
+	assert.Equal(t, uint32(6), first.Statuses[0].LimitRemaining)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, second.OverallCode)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, third.OverallCode, "Third request of 4 hits should exceed 10 tokens")
+}
+
+func TestHTTPGateway_CheckAndRefill(t *testing.T) {
+	userID := generateRandomUserID()
+
+	post := func(path, body string) (int, map[string]any) {
+		t.Helper()
+		resp, err := http.Post(httpAddr+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to call %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+		return resp.StatusCode, out
+	}
+
+	// Act
+	checkStatus, check := post("/v1/check", `{"key": "`+userID+`", "token_cost": 10}`)
+	refillStatus, refill := post("/v1/refill", `{"key": "`+userID+`", "leak_rate": 3}`)
+	badStatus, bad := post("/v1/check", `{"key": `)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, checkStatus)
+	assert.Equal(t, true, check["allowed"])
+	assert.Equal(t, float64(0), check["remaining"])
+	assert.Equal(t, http.StatusOK, refillStatus)
+	assert.Equal(t, float64(3), refill["current_tokens"])
+	assert.Equal(t, http.StatusBadRequest, badStatus)
+	assert.Contains(t, bad, "error")
+}